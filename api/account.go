@@ -0,0 +1,123 @@
+package api
+
+import (
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	services "LavanderiaBackend/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// sendVerification mails a verification token to a new account. Failing to send it doesn't undo the
+// registration, the user can ask for another one.
+func sendVerification(accounts *services.AccountService, user model.User) {
+	if user.Email == "" {
+		return
+	}
+	if err := accounts.SendVerification(user); err != nil {
+		log.Printf("Error sending verification email to user %s: %v", user.Id, err)
+	}
+}
+
+// ForgotPassword answers 202 whether or not the email has an account, so it doesn't reveal who has one.
+// Requests are throttled per email and per IP like logins, so the endpoint can't flood an inbox.
+func ForgotPassword(c *gin.Context, accounts *services.AccountService, guard *services.LoginGuard) {
+	var body struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	key := services.PasswordResetKey(body.Email)
+	release, answered := throttleLogin(c, guard, key)
+	if answered {
+		return
+	}
+	// Recorded as a failure whether or not the email has an account, nothing ever resets the count
+	err := guard.Record(model.LoginAttempt{Username: key, IP: c.ClientIP(), UserAgent: c.Request.UserAgent(), Reason: "password reset requested"})
+	release()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := accounts.RequestPasswordReset(body.Email); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email belongs to an account, a reset token was sent to it"})
+}
+
+// ResetPassword sets a new password with a reset token. Wrong tokens count against the IP like failed
+// logins.
+func ResetPassword(c *gin.Context, accounts *services.AccountService, guard *services.LoginGuard) {
+	var body struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	if waitForIP(c, guard) {
+		return
+	}
+	err := accounts.ResetPassword(body.Token, body.Password)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		attempt := model.LoginAttempt{IP: c.ClientIP(), UserAgent: c.Request.UserAgent(), Reason: "invalid password reset token"}
+		if err := guard.Record(attempt); err != nil {
+			log.Printf("Error recording a password reset attempt: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, sign in again"})
+}
+
+func VerifyEmail(c *gin.Context, accounts *services.AccountService) {
+	var body struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	err := accounts.VerifyEmail(body.Token)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification mails a new verification token to the authenticated user.
+func ResendVerification(c *gin.Context, userRepo *repository.UserRepository, accounts *services.AccountService) {
+	user, err := userRepo.GetUserByID(currentUserID(c).String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified"})
+		return
+	}
+	err = accounts.SendVerification(user)
+	if errors.Is(err, services.ErrNoEmail) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// CreateAPIKey creates a key with some of the permissions of the user creating it. The key is only
+// ever returned here.
+func CreateAPIKey(c *gin.Context, apiKeyRepo *repository.APIKeyRepository, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
+	var body struct {
+		Name        string     `json:"name" binding:"required"`
+		Permissions []string   `json:"permissions" binding:"required"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	creator := c.MustGet("user").(model.User)
+	allowed, err := roleRepo.HasAllPermissions(creator.Role, body.Permissions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant permissions you don't have"})
+		return
+	}
+
+	key, keyHash, err := auth.NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	apiKey := model.APIKey{
+		Name:      body.Name,
+		Prefix:    key[:len(auth.APIKeyPrefix)+6],
+		KeyHash:   keyHash,
+		CreatedBy: creator.Id,
+		ExpiresAt: body.ExpiresAt,
+	}
+	if err := apiKeyRepo.CreateAPIKey(&apiKey, body.Permissions); err != nil {
+		c.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditCreate, "api_key", apiKey.Id, nil, apiKey)
+	c.JSON(http.StatusCreated, gin.H{"api_key": apiKey, "key": key})
+}
+
+func GetAllAPIKeys(c *gin.Context, apiKeyRepo *repository.APIKeyRepository) {
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	keys, err := apiKeyRepo.GetAllAPIKeys(query)
+	if err != nil {
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func RevokeAPIKey(c *gin.Context, apiKeyRepo *repository.APIKeyRepository, audit *repository.AuditRepository) {
+	err := apiKeyRepo.RevokeAPIKey(c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found or already revoked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditDelete, "api_key", c.Param("id"), nil, nil)
+	c.JSON(http.StatusNoContent, gin.H{"api_key": nil})
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordAudit appends an audit entry for a change made by the request. before is nil for creations
+// and after is nil for deletions. A failure to record is logged rather than undoing the change.
+func recordAudit(c *gin.Context, audit *repository.AuditRepository, action model.AuditAction, entityType string, entityID interface{}, before, after interface{}) {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		log.Printf("Error computing audit changes for %s %v: %v", entityType, entityID, err)
+	}
+	entry := model.AuditEntry{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		Changes:    changes,
+		IP:         c.ClientIP(),
+	}
+	if value, exists := c.Get("user"); exists {
+		user := value.(model.User)
+		entry.ActorType = model.ActorUser
+		entry.ActorID = &user.Id
+		entry.ActorName = user.Username
+	} else if value, exists := c.Get("api_key"); exists {
+		key := value.(model.APIKey)
+		entry.ActorType = model.ActorAPIKey
+		entry.ActorID = &key.Id
+		entry.ActorName = key.Name
+	}
+	if err := audit.Record(&entry); err != nil {
+		log.Printf("Error recording audit entry for %s %s %v: %v", action, entityType, entityID, err)
+	}
+}
+
+// auditChanges returns the top level JSON fields that differ between before and after.
+func auditChanges(before, after interface{}) (json.RawMessage, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]map[string]interface{}{}
+	for name, value := range beforeFields {
+		if other, ok := afterFields[name]; !ok || !reflect.DeepEqual(value, other) {
+			changes[name] = map[string]interface{}{"before": value, "after": afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			changes[name] = map[string]interface{}{"before": nil, "after": value}
+		}
+	}
+	return json.Marshal(changes)
+}
+
+func jsonFields(entity interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if entity == nil {
+		return fields, nil
+	}
+	raw, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(raw, &fields)
+}
+
+// GetAuditLog lists audit entries filtered by actor_id, action, entity_type, entity_id and an RFC 3339
+// from/to range. With format=csv, or when CSV is the accepted type, it downloads them as CSV.
+func GetAuditLog(c *gin.Context, audit *repository.AuditRepository) {
+	filter := model.AuditFilter{
+		ActorID:    c.Query("actor_id"),
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+	}
+	var err error
+	if filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "500")); err != nil || filter.Limit <= 0 || filter.Limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(param); value != "" {
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time"})
+				return
+			}
+		}
+	}
+
+	entries, err := audit.GetEntries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("format") != "csv" && c.NegotiateFormat(gin.MIMEJSON, "text/csv") != "text/csv" {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s.csv", time.Now().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"id", "created_at", "actor_type", "actor_id", "actor_name", "action", "entity_type", "entity_id", "ip", "changes"})
+	for _, entry := range entries {
+		actorID := ""
+		if entry.ActorID != nil {
+			actorID = entry.ActorID.String()
+		}
+		writer.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreatedAt.Format(time.RFC3339),
+			entry.ActorType,
+			actorID,
+			csvText(entry.ActorName),
+			string(entry.Action),
+			csvText(entry.EntityType),
+			csvText(entry.EntityID),
+			entry.IP,
+			csvText(string(entry.Changes)),
+		})
+	}
+	writer.Flush()
+}
+
+// csvText keeps user supplied text from being read as a formula when the export is opened in a
+// spreadsheet, by quoting values that start like one.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
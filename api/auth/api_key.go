@@ -0,0 +1,24 @@
+package auth
+
+import "strings"
+
+// APIKeyPrefix starts every API key, so they are easy to recognize in headers and secret scanners.
+const APIKeyPrefix = "lvk_"
+
+// NewAPIKey returns a random API key and the hash to store in its place.
+func NewAPIKey() (string, string, error) {
+	token, _, err := NewOneTimeToken()
+	if err != nil {
+		return "", "", err
+	}
+	key := APIKeyPrefix + token
+	return key, HashAPIKey(key), nil
+}
+
+func HashAPIKey(key string) string {
+	return HashOneTimeToken(key)
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
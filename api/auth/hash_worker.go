@@ -1,13 +1,42 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"golang.org/x/crypto/bcrypt"
+	"runtime"
+	"sync"
+)
+
+const bcryptCost = 14
+
+// hashSlots caps how many bcrypt computations run at once, so a flood of logins queues up instead of
+// starving every other request of CPU.
+var hashSlots = make(chan struct{}, runtime.NumCPU())
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
 
 func HashingPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	hashSlots <- struct{}{}
+	defer func() { <-hashSlots }()
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	return string(bytes), err
 }
 
 func CheckPasswordHash(password, hash string) bool {
+	hashSlots <- struct{}{}
+	defer func() { <-hashSlots }()
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// CheckPasswordAgainstNothing spends as long as CheckPasswordHash and always fails. Logins for unknown
+// usernames call it so response times don't reveal which usernames exist.
+func CheckPasswordAgainstNothing(password string) bool {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no user has this password"), bcryptCost)
+	})
+	CheckPasswordHash(password, string(dummyHash))
+	return false
+}
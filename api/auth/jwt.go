@@ -6,28 +6,46 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
-var JwtKey = []byte("my_secret_key") // Should be in a secure place or from environment variables
+// AccessTokenTTL is kept short because access tokens are only checked against their session, refresh
+// tokens are what keep staff logged in during a shift.
+const AccessTokenTTL = 15 * time.Minute
 
+// ChallengeTTL is how long a user has to enter their second factor after the password.
+const ChallengeTTL = 5 * time.Minute
+
+// challengeAudience marks the tokens that only prove the password step of a two-factor login, so they
+// are never mistaken for access tokens.
+const challengeAudience = "2fa-challenge"
+
+// Claims only identify the user and session. Everything else about the user is loaded from the
+// database on every request, so tokens never carry password hashes or outdated privileges.
 type Claims struct {
-	User model.User `json:"user"`
+	Role      string    `json:"role"` // Informational only, never used for authorization
+	SessionID uuid.UUID `json:"sid"`
 	jwt.StandardClaims
 }
 
-// GenerateToken generates a jwt token and assign a username to it's claims and return it
-func GenerateToken(user model.User) (string, error) {
-	expirationTime := time.Now().Add(1 * time.Hour) // Token expires after 1 hour
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// GenerateToken generates a jwt access token for the user session and returns it
+func GenerateToken(user model.User, sessionID uuid.UUID) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 	claims := &Claims{
-		User: user,
+		Role:      user.Role,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
+			Subject:   user.Id.String(),
+			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKey)
-	return tokenString, err
+	return signToken(claims)
 }
 
 /*func ValidateToken(signedToken string) (*Claims, error) {
@@ -52,10 +70,8 @@ func GenerateToken(user model.User) (string, error) {
 }*/
 
 func ValidateToken(signedToken string) (*Claims, error) {
-	// Parse the token.
-	token, err := jwt.ParseWithClaims(signedToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return JwtKey, nil
-	})
+	// Parse the token with the key named in its header.
+	token, err := jwt.ParseWithClaims(signedToken, &Claims{}, verificationKey)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing token: %w", err)
 	}
@@ -70,5 +86,34 @@ func ValidateToken(signedToken string) (*Claims, error) {
 		return nil, fmt.Errorf("jwt is expired")
 	}
 
+	if claims.Audience == challengeAudience {
+		return nil, fmt.Errorf("two-factor challenge is not an access token")
+	}
+
 	return claims, nil
 }
+
+// GenerateChallengeToken returns the token a user who passed the password step trades, with their
+// second factor, for a session.
+func GenerateChallengeToken(user model.User) (string, error) {
+	claims := &jwt.StandardClaims{
+		Subject:   user.Id.String(),
+		Audience:  challengeAudience,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(ChallengeTTL).Unix(),
+	}
+	return signToken(claims)
+}
+
+// ValidateChallengeToken returns the ID of the user the challenge was issued to.
+func ValidateChallengeToken(signedToken string) (uuid.UUID, error) {
+	token, err := jwt.ParseWithClaims(signedToken, &jwt.StandardClaims{}, verificationKey)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error parsing challenge: %w", err)
+	}
+	claims, ok := token.Claims.(*jwt.StandardClaims)
+	if !ok || !token.Valid || claims.Audience != challengeAudience {
+		return uuid.Nil, fmt.Errorf("invalid challenge")
+	}
+	return uuid.Parse(claims.Subject)
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"LavanderiaBackend/config"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519, which the jwt-go version we use doesn't ship.
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+type signingMethodEd25519 struct{}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	publicKey crypto.PublicKey // Nil for shared secrets, which must never be published
+}
+
+var (
+	signingKeys   = map[string]*signingKey{}
+	activeKey     *signingKey
+	errNoKeys     = errors.New("no jwt signing keys configured")
+	errUnknownKid = errors.New("token signed with an unknown key")
+)
+
+// LoadKeys sets up the token signing keys from the configuration. It has to be called before any
+// token is generated or validated.
+func LoadKeys(cfg *config.Config) error {
+	keys := map[string]*signingKey{}
+	for _, entry := range cfg.JWTKeys {
+		key, err := parseSigningKey(entry)
+		if err != nil {
+			return fmt.Errorf("jwt key %s: %w", entry.ID, err)
+		}
+		keys[entry.ID] = key
+	}
+	if len(keys) == 0 {
+		return errNoKeys
+	}
+	active, ok := keys[cfg.JWTActiveKeyID]
+	if !ok {
+		return fmt.Errorf("active jwt key %q is not configured", cfg.JWTActiveKeyID)
+	}
+	signingKeys = keys
+	activeKey = active
+	return nil
+}
+
+func parseSigningKey(entry config.JWTKey) (*signingKey, error) {
+	algorithm := entry.Algorithm
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		secret := []byte(entry.Material)
+		return &signingKey{id: entry.ID, method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+	}
+
+	pemBytes, err := os.ReadFile(entry.Material)
+	if err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		return &signingKey{id: entry.ID, method: jwt.SigningMethodRS256, signKey: privateKey, verifyKey: &privateKey.PublicKey, publicKey: &privateKey.PublicKey}, nil
+	case SigningMethodEdDSA.Alg():
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, errors.New("key is not PEM encoded")
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("key is not an Ed25519 private key")
+		}
+		publicKey := privateKey.Public().(ed25519.PublicKey)
+		return &signingKey{id: entry.ID, method: SigningMethodEdDSA, signKey: privateKey, verifyKey: publicKey, publicKey: publicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported jwt algorithm %q", algorithm)
+}
+
+// signToken signs the claims with the active key and names it in the kid header.
+func signToken(claims jwt.Claims) (string, error) {
+	if activeKey == nil {
+		return "", errNoKeys
+	}
+	token := jwt.NewWithClaims(activeKey.method, claims)
+	token.Header["kid"] = activeKey.id
+	return token.SignedString(activeKey.signKey)
+}
+
+// verificationKey picks the key named by the token kid header, refusing tokens that claim a
+// different algorithm than that key was configured with.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := signingKeys[kid]
+	if !ok {
+		return nil, errUnknownKid
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// PublicKeys returns the public half of every asymmetric signing key so other services can verify our
+// tokens. It is empty when tokens are signed with a shared secret.
+func PublicKeys() []JWK {
+	jwks := []JWK{}
+	for id, key := range signingKeys {
+		switch publicKey := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,19 @@
+package auth
+
+import "time"
+
+const (
+	InvitationTTL        = 7 * 24 * time.Hour
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+)
+
+// NewOneTimeToken returns a random token that is handed out once, like an invitation, and the hash
+// to store in its place. It is generated and hashed the same way as refresh tokens.
+func NewOneTimeToken() (string, string, error) {
+	return NewRefreshToken()
+}
+
+func HashOneTimeToken(token string) string {
+	return HashRefreshToken(token)
+}
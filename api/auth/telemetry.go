@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+)
+
+// DeviceKey derives the key a machine signs its telemetry with from the server telemetry key, so
+// every machine has its own key without storing one per machine.
+func DeviceKey(telemetryKey []byte, machineID uuid.UUID) []byte {
+	mac := hmac.New(sha256.New, telemetryKey)
+	mac.Write([]byte(machineID.String()))
+	return mac.Sum(nil)
+}
+
+// SignTelemetry returns the hex encoded HMAC-SHA256 of the payload under the machine device key.
+func SignTelemetry(deviceKey []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, deviceKey)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyTelemetry(deviceKey []byte, payload []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, deviceKey)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Vectors are the SHA1 test vectors of RFC 6238 appendix B, cut to the six digits we use.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+const rfc6238Key = "12345678901234567890"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, vector := range rfc6238Vectors {
+		step := vector.unix / int64(totpPeriod.Seconds())
+		if got := totpCode([]byte(rfc6238Key), step); got != vector.code {
+			t.Errorf("totpCode at %d = %s, want %s", vector.unix, got, vector.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(rfc6238Key))
+	for _, vector := range rfc6238Vectors {
+		step := vector.unix / int64(totpPeriod.Seconds())
+		for _, drift := range []time.Duration{0, -totpPeriod, totpPeriod} {
+			now := time.Unix(vector.unix, 0).Add(drift)
+			got, ok := VerifyTOTP(secret, vector.code, now)
+			if !ok || got != step {
+				t.Errorf("VerifyTOTP(%s) at %d drifted %s = %d, %v, want %d, true", vector.code, vector.unix, drift, got, ok, step)
+			}
+		}
+		if _, ok := VerifyTOTP(secret, vector.code, time.Unix(vector.unix, 0).Add(3*totpPeriod)); ok {
+			t.Errorf("VerifyTOTP(%s) accepted a code three steps old", vector.code)
+		}
+	}
+
+	now := time.Unix(59, 0)
+	for _, code := range []string{"287 082", "287082 "} {
+		if _, ok := VerifyTOTP(secret, code, now); !ok {
+			t.Errorf("VerifyTOTP(%q) refused the code with spaces", code)
+		}
+	}
+	for _, code := range []string{"", "287083", "28708", "2870820", "abcdef"} {
+		if _, ok := VerifyTOTP(secret, code, now); ok {
+			t.Errorf("VerifyTOTP(%q) accepted a wrong code", code)
+		}
+	}
+	if _, ok := VerifyTOTP("not base32!", "287082", now); ok {
+		t.Error("VerifyTOTP accepted a malformed secret")
+	}
+}
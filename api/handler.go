@@ -4,17 +4,31 @@ import (
 	"LavanderiaBackend/api/auth"
 	"LavanderiaBackend/model"
 	"LavanderiaBackend/repository"
+	services "LavanderiaBackend/service"
+	"encoding/hex"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
 	"net/http"
 	"strconv"
 )
 
-func CreateUser(c *gin.Context, repo *repository.UserRepository) {
+func CreateUser(c *gin.Context, repo *repository.UserRepository, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
 	var user model.User
 	if err := c.BindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if user.Role == "" {
+		user.Role = model.RoleCustomer
+	}
+	user.EmailVerifiedAt = nil
+	user.TOTPEnabledAt = nil
+	if !checkGrantableRole(c, roleRepo, user.Role) {
+		return
+	}
 	var err error
 	user.Password, err = auth.HashingPassword(user.Password)
 	if err != nil {
@@ -25,13 +39,18 @@ func CreateUser(c *gin.Context, repo *repository.UserRepository) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditCreate, "user", user.Id, nil, user)
 	c.JSON(http.StatusCreated, gin.H{"user": user})
 }
 
 func GetAllUsers(c *gin.Context, repo *repository.UserRepository) {
-	users, err := repo.GetAllUsers()
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	users, err := repo.GetAllUsers(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, users)
@@ -47,37 +66,84 @@ func GetUserByID(c *gin.Context, repo *repository.UserRepository) {
 	c.JSON(http.StatusOK, user)
 }
 
-func UpdateUser(c *gin.Context, repo *repository.UserRepository) {
-	var user model.User
-	if err := c.BindJSON(&user); err != nil {
+// userUpdate is what staff may change on an account. Fields left empty keep their current value.
+type userUpdate struct {
+	Id       uuid.UUID `json:"id" binding:"required"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	Password string    `json:"password"`
+}
+
+func UpdateUser(c *gin.Context, repo *repository.UserRepository, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
+	var body userUpdate
+	if err := c.BindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := repo.GetUserByID(user.Id.String())
+	existing, err := repo.GetUserByID(body.Id.String())
 	if err != nil {
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
 		return
 	}
+	if !checkManageableUser(c, roleRepo, existing) {
+		return
+	}
+	if body.Role != "" && !checkGrantableRole(c, roleRepo, body.Role) {
+		return
+	}
+	// Everything else, two-factor settings included, only changes through its own endpoints
+	user := existing
+	if body.Username != "" {
+		user.Username = body.Username
+	}
+	if body.Email != "" {
+		user.Email = body.Email
+	}
+	if body.Role != "" {
+		user.Role = body.Role
+	}
+	if body.Password != "" {
+		user.Password, err = auth.HashingPassword(body.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// Only a verification token can mark an email as verified, and a new email has to be verified again
+	if user.Email != existing.Email {
+		user.EmailVerifiedAt = nil
+	}
 	err = repo.UpdateUser(&user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditUpdate, "user", user.Id, existing, user)
 	c.JSON(http.StatusOK, user)
 
 }
 
-func DeleteUser(c *gin.Context, repo *repository.UserRepository) {
+func DeleteUser(c *gin.Context, repo *repository.UserRepository, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
 	id := c.Param("id")
-	err := repo.DeleteUser(id)
+	user, err := repo.GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkManageableUser(c, roleRepo, user) {
+		return
+	}
+	err = repo.DeleteUser(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditDelete, "user", user.Id, user, nil)
 	c.JSON(http.StatusNoContent, gin.H{"user": nil})
 }
 
-func CreateProduct(c *gin.Context, repo *repository.ProductRepository) {
+func CreateProduct(c *gin.Context, repo *repository.ProductRepository, audit *repository.AuditRepository) {
 	var product model.Product
 	if err := c.BindJSON(&product); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -88,13 +154,18 @@ func CreateProduct(c *gin.Context, repo *repository.ProductRepository) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditCreate, "product", product.Id, nil, product)
 	c.JSON(http.StatusCreated, gin.H{"product": product})
 }
 
 func GetAllProducts(c *gin.Context, repo *repository.ProductRepository) {
-	products, err := repo.GetAllProducts()
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	products, err := repo.GetAllProducts(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, products)
@@ -110,14 +181,14 @@ func GetProductByName(c *gin.Context, repo *repository.ProductRepository) {
 	c.JSON(http.StatusOK, product)
 }
 
-func UpdateProduct(c *gin.Context, repo *repository.ProductRepository) {
+func UpdateProduct(c *gin.Context, repo *repository.ProductRepository, audit *repository.AuditRepository) {
 	var product model.Product
 	if err := c.BindJSON(&product); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err := repo.GetProductByID(strconv.FormatInt(int64(product.ID), 10))
+	existing, err := repo.GetProductByID(strconv.FormatInt(int64(product.ID), 10))
 	if err != nil {
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
 		return
@@ -127,20 +198,26 @@ func UpdateProduct(c *gin.Context, repo *repository.ProductRepository) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditUpdate, "product", product.Id, existing, product)
 	c.JSON(http.StatusNoContent, gin.H{"product": product})
 }
 
-func DeleteProduct(c *gin.Context, repo *repository.ProductRepository) {
-	id := c.Param("id")
-	err := repo.DeleteProduct(id)
+func DeleteProduct(c *gin.Context, repo *repository.ProductRepository, audit *repository.AuditRepository) {
+	product, err := repo.GetProductByID(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	err = repo.DeleteProduct(strconv.FormatInt(int64(product.Id), 10))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditDelete, "product", product.Id, product, nil)
 	c.JSON(http.StatusNoContent, gin.H{"product": nil})
 }
 
-func CreateClient(c *gin.Context, repo *repository.ClientRepository) {
+func CreateClient(c *gin.Context, repo *repository.ClientRepository, audit *repository.AuditRepository) {
 	var client model.Client
 	if err := c.BindJSON(&client); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -151,13 +228,18 @@ func CreateClient(c *gin.Context, repo *repository.ClientRepository) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditCreate, "client", client.Id, nil, client)
 	c.JSON(http.StatusCreated, gin.H{"client": client})
 }
 
 func GetAllClients(c *gin.Context, repo *repository.ClientRepository) {
-	clients, err := repo.GetAllClients()
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	clients, err := repo.GetAllClients(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, clients)
@@ -173,13 +255,13 @@ func GetClientByID(c *gin.Context, repo *repository.ClientRepository) {
 	c.JSON(http.StatusOK, client)
 }
 
-func UpdateClient(c *gin.Context, repo *repository.ClientRepository) {
+func UpdateClient(c *gin.Context, repo *repository.ClientRepository, audit *repository.AuditRepository) {
 	var client model.Client
 	if err := c.BindJSON(&client); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := repo.GetClientByID(client.Id.String())
+	existing, err := repo.GetClientByID(client.Id.String())
 	if err != nil {
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
 		return
@@ -187,37 +269,88 @@ func UpdateClient(c *gin.Context, repo *repository.ClientRepository) {
 	err = repo.UpdateClient(&client)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	recordAudit(c, audit, model.AuditUpdate, "client", client.Id, existing, client)
 	c.JSON(http.StatusNoContent, gin.H{"client": client})
 }
 
-func DeleteClient(c *gin.Context, repo *repository.ClientRepository) {
+func DeleteClient(c *gin.Context, repo *repository.ClientRepository, audit *repository.AuditRepository) {
 	id := c.Param("id")
-	err := repo.DeleteClient(id)
+	client, err := repo.GetClientByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	err = repo.DeleteClient(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	recordAudit(c, audit, model.AuditDelete, "client", client.Id, client, nil)
 	c.JSON(http.StatusNoContent, gin.H{"client": nil})
 }
 
-func CreateRequest(c *gin.Context, repo *repository.RequestRepository) {
-	var request model.Request
-	if err := c.BindJSON(&request); err != nil {
+// requestBody is a request as sent by clients, who describe its lines or only order catalogue services
+// by ID.
+type requestBody struct {
+	model.Request
+	Lines      []model.ServiceOrder `json:"lines"`
+	ServiceIDs []model.ServiceOrder `json:"service_ids"`
+}
+
+// serviceOrders returns the lines ordered. Older clients send the services themselves, only their IDs
+// are used.
+func (body *requestBody) serviceOrders() []model.ServiceOrder {
+	if len(body.Lines) > 0 {
+		return body.Lines
+	}
+	if len(body.ServiceIDs) > 0 {
+		return body.ServiceIDs
+	}
+	orders := make([]model.ServiceOrder, 0, len(body.Services))
+	for _, service := range body.Services {
+		orders = append(orders, model.ServiceOrder{ServiceID: service.Id})
+	}
+	return orders
+}
+
+func CreateRequest(c *gin.Context, repo *repository.RequestRepository, roleRepo *repository.RoleRepository, pricing *services.PricingService, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	var body requestBody
+	if err := c.BindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	err := repo.CreateRequest(&request)
+	request := body.Request
+	if request.HasClientTotals() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totals and line items are calculated by the server"})
+		return
+	}
+	if !checkDiscount(c, roleRepo, request.DiscountPercent, 0) {
+		return
+	}
+	if err := pricing.PriceRequest(&request, body.serviceOrders(), nil); err != nil {
+		c.JSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	err := repo.CreateRequest(&request, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditCreate, "request", request.Id, nil, request)
+	assigner.Notify()
 	c.JSON(http.StatusCreated, gin.H{"product": request})
 }
 
 func GetAllRequests(c *gin.Context, repo *repository.RequestRepository) {
-	requests, err := repo.GetAllRequests()
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	requests, err := repo.GetAllRequests(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, requests)
@@ -225,7 +358,11 @@ func GetAllRequests(c *gin.Context, repo *repository.RequestRepository) {
 
 func GetRequestByID(c *gin.Context, repo *repository.RequestRepository) {
 	id := c.Param("id")
-	request, err := repo.GetRequestByID(id)
+	request, err := repo.GetRequestByID(id, expandParam(c)...)
+	if errors.Is(err, repository.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -233,36 +370,157 @@ func GetRequestByID(c *gin.Context, repo *repository.RequestRepository) {
 	c.JSON(http.StatusOK, request)
 }
 
-func UpdateRequest(c *gin.Context, repo *repository.RequestRepository) {
-	var request model.Request
-	if err := c.BindJSON(&request); err != nil {
+func UpdateRequest(c *gin.Context, repo *repository.RequestRepository, roleRepo *repository.RoleRepository, pricing *services.PricingService, audit *repository.AuditRepository) {
+	var body requestBody
+	if err := c.BindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := repo.GetRequestByID(request.Id.String())
+	request := body.Request
+	if request.HasClientTotals() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totals and line items are calculated by the server"})
+		return
+	}
+	existing, err := repo.GetRequestByID(request.Id.String(), "line_items")
 	if err != nil {
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
 		return
 	}
-	err = repo.UpdateRequest(&request)
+	if !checkDiscount(c, roleRepo, request.DiscountPercent, existing.DiscountPercent) {
+		return
+	}
+	// The status and machine only change through the transition endpoint and the assigner, the
+	// repository keeps them as stored. The client and creation date never change.
+	request.Model = existing.Model
+	request.ClientID = existing.ClientID
+	orders := body.serviceOrders()
+	linesChanged := len(orders) > 0
+	if linesChanged && !existing.LineItemsEditable() {
+		c.JSON(http.StatusConflict, gin.H{"error": model.ErrLineItemsLocked.Error()})
+		return
+	}
+	if len(orders) == 0 {
+		// Lines left out of the update stay as they were
+		for _, item := range existing.LineItems {
+			orders = append(orders, item.Order())
+		}
+	}
+	if !existing.LineItemsEditable() {
+		request.LoadWeight = existing.LoadWeight
+	}
+	if err := pricing.PriceRequest(&request, orders, existing.LineItems); err != nil {
+		c.JSON(pricingErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	err = repo.UpdateRequest(&request, linesChanged)
+	if errors.Is(err, model.ErrLineItemsLocked) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditUpdate, "request", request.Id, existing, request)
 	c.JSON(http.StatusNoContent, gin.H{"request": request})
 }
 
-func DeleteRequest(c *gin.Context, repo *repository.RequestRepository) {
+func DeleteRequest(c *gin.Context, repo *repository.RequestRepository, audit *repository.AuditRepository) {
 	id := c.Param("id")
-	err := repo.DeleteRequestByID(id)
+	request, err := repo.GetRequestByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	err = repo.DeleteRequestByID(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditDelete, "request", request.Id, request, nil)
 	c.JSON(http.StatusNoContent, gin.H{"request": nil})
 }
 
-func CreateService(c *gin.Context, repo *repository.ServiceRepository) {
+func TransitionRequest(c *gin.Context, repo *repository.RequestRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	var body struct {
+		Status model.RequestStatus `json:"status" binding:"required"`
+		Note   string              `json:"note"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !body.Status.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status " + string(body.Status)})
+		return
+	}
+	id := c.Param("id")
+	existing, err := repo.GetRequestByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	request, err := repo.TransitionRequest(id, body.Status, currentUserID(c), body.Note)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, model.ErrInvalidStatusTransition) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditUpdate, "request", request.Id, existing, request)
+	if request.Status == model.StatusQueued {
+		assigner.Notify()
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+// checkDiscount answers the request and returns false when it changes the discount of an order from
+// current without the permission to give discounts.
+func checkDiscount(c *gin.Context, roleRepo *repository.RoleRepository, discount, current float64) bool {
+	if discount == current {
+		return true
+	}
+	allowed, err := hasPermission(c, roleRepo, model.PermRequestsDiscount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission " + model.PermRequestsDiscount})
+		return false
+	}
+	return true
+}
+
+func pricingErrorStatus(err error) int {
+	if errors.Is(err, services.ErrUnknownService) || errors.Is(err, services.ErrInactiveService) ||
+		errors.Is(err, services.ErrInvalidPricingInput) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func GetRequestStatusHistory(c *gin.Context, repo *repository.RequestRepository) {
+	id := c.Param("id")
+	if _, err := repo.GetRequestByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	history, err := repo.GetStatusHistory(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+func CreateService(c *gin.Context, repo *repository.ServiceRepository, audit *repository.AuditRepository) {
 	var service model.Service
 	if err := c.BindJSON(&service); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -273,13 +531,18 @@ func CreateService(c *gin.Context, repo *repository.ServiceRepository) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditCreate, "service", service.Id, nil, service)
 	c.JSON(http.StatusCreated, gin.H{"service": service})
 }
 
 func GetAllServices(c *gin.Context, repo *repository.ServiceRepository) {
-	services, err := repo.GetAllServices()
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	services, err := repo.GetAllServices(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, services)
@@ -287,7 +550,11 @@ func GetAllServices(c *gin.Context, repo *repository.ServiceRepository) {
 
 func GetServiceByID(c *gin.Context, repo *repository.ServiceRepository) {
 	id := c.Param("id")
-	service, err := repo.GetServiceByID(id)
+	service, err := repo.GetServiceByID(id, expandParam(c)...)
+	if errors.Is(err, repository.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -295,13 +562,13 @@ func GetServiceByID(c *gin.Context, repo *repository.ServiceRepository) {
 	c.JSON(http.StatusOK, service)
 }
 
-func UpdateService(c *gin.Context, repo *repository.ServiceRepository) {
+func UpdateService(c *gin.Context, repo *repository.ServiceRepository, audit *repository.AuditRepository) {
 	var service model.Service
 	if err := c.BindJSON(&service); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := repo.GetServiceByID(strconv.FormatInt(int64(service.Id), 10))
+	existing, err := repo.GetServiceByID(strconv.FormatInt(int64(service.Id), 10))
 	if err != nil {
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
 		return
@@ -311,37 +578,52 @@ func UpdateService(c *gin.Context, repo *repository.ServiceRepository) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditUpdate, "service", service.Id, existing, service)
 	c.JSON(http.StatusNoContent, gin.H{"service": service})
 }
 
-func DeleteService(c *gin.Context, repo *repository.ServiceRepository) {
+func DeleteService(c *gin.Context, repo *repository.ServiceRepository, audit *repository.AuditRepository) {
 	id := c.Param("id")
-	err := repo.DeleteService(id)
+	service, err := repo.GetServiceByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	err = repo.DeleteService(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditDelete, "service", service.Id, service, nil)
 	c.JSON(http.StatusNoContent, gin.H{"service": nil})
 }
 
-func CreateWashingMachine(c *gin.Context, repo *repository.WashingMachineRepository) {
+func CreateWashingMachine(c *gin.Context, repo *repository.WashingMachineRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
 	var washingMachine model.WashingMachine
 	if err := c.BindJSON(&washingMachine); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	washingMachine.Status = model.MachineAvailable
+	washingMachine.Occupied = false
 	err := repo.CreateWashingMachine(&washingMachine)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditCreate, "washing_machine", washingMachine.Id, nil, washingMachine)
+	assigner.Notify()
 	c.JSON(http.StatusCreated, gin.H{"washingMachine": washingMachine})
 }
 
 func GetAllWashingMachines(c *gin.Context, repo *repository.WashingMachineRepository) {
-	washingMachines, err := repo.GetAllWashingMachines()
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	washingMachines, err := repo.GetAllWashingMachines(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, washingMachines)
@@ -357,31 +639,233 @@ func GetWashingMachineByID(c *gin.Context, repo *repository.WashingMachineReposi
 	c.JSON(http.StatusOK, washingMachine)
 }
 
-func UpdateWashingMachine(c *gin.Context, repo *repository.WashingMachineRepository) {
+func UpdateWashingMachine(c *gin.Context, repo *repository.WashingMachineRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
 	var washingMachine model.WashingMachine
 	if err := c.BindJSON(&washingMachine); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := repo.GetWashingMachineByID(washingMachine.Id.String())
+	existing, err := repo.GetWashingMachineByID(washingMachine.Id.String())
 	if err != nil {
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": err.Error()})
 		return
 	}
+	// Status changes through cycles, faults and maintenance windows only, the rest comes from telemetry
+	washingMachine.Status = existing.Status
+	washingMachine.Occupied = existing.Occupied
+	washingMachine.DoorOpen = existing.DoorOpen
+	washingMachine.RemainingSeconds = existing.RemainingSeconds
+	washingMachine.ErrorCode = existing.ErrorCode
+	washingMachine.LastTelemetryAt = existing.LastTelemetryAt
+	washingMachine.TelemetrySequence = existing.TelemetrySequence
 	err = repo.UpdateWashingMachine(&washingMachine)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditUpdate, "washing_machine", washingMachine.Id, existing, washingMachine)
+	assigner.Notify()
 	c.JSON(http.StatusNoContent, gin.H{"washingMachine": washingMachine})
 }
 
-func DeleteWashingMachine(c *gin.Context, repo *repository.WashingMachineRepository) {
+func DeleteWashingMachine(c *gin.Context, repo *repository.WashingMachineRepository, audit *repository.AuditRepository) {
 	id := c.Param("id")
-	err := repo.DeleteWashingMachine(id)
+	washingMachine, err := repo.GetWashingMachineByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	err = repo.DeleteWashingMachine(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, audit, model.AuditDelete, "washing_machine", washingMachine.Id, washingMachine, nil)
 	c.JSON(http.StatusNoContent, gin.H{"washingMachine": nil})
 }
+
+func GetRequestAssignments(c *gin.Context, repo *repository.WashingMachineRepository) {
+	id := c.Param("id")
+	assignments, err := repo.GetAssignmentsByRequest(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+func FinishMachineCycle(c *gin.Context, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	var body struct {
+		Outcome model.CycleOutcome `json:"outcome" binding:"required"`
+		Note    string             `json:"note"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Outcome != model.CycleFinishedEarly && body.Outcome != model.CycleFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "outcome must be finished_early or failed"})
+		return
+	}
+	machineId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = assigner.FinishCycle(machineId, body.Outcome, body.Note)
+	if errors.Is(err, repository.ErrNoRunningCycle) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditUpdate, "washing_machine", machineId, nil, gin.H{"cycle_outcome": body.Outcome, "note": body.Note})
+	c.JSON(http.StatusOK, gin.H{"machine_id": machineId, "outcome": body.Outcome})
+}
+
+func ReportMachineFault(c *gin.Context, repo *repository.WashingMachineRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	var body struct {
+		Description string `json:"description" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	machine, err := repo.GetWashingMachineByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	fault := model.MachineFault{
+		MachineID:   machine.Id,
+		Description: body.Description,
+		ReportedBy:  currentUserID(c),
+	}
+	created, err := assigner.ReportFault(&fault)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, gin.H{"fault": fault})
+		return
+	}
+	recordAudit(c, audit, model.AuditCreate, "machine_fault", fault.ID, nil, fault)
+	c.JSON(http.StatusCreated, gin.H{"fault": fault})
+}
+
+func ResolveMachineFault(c *gin.Context, repo *repository.MaintenanceRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	var body struct {
+		Resolution string `json:"resolution"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fault, err := repo.ResolveFault(c.Param("id"), c.Param("faultId"), body.Resolution)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "open fault not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditUpdate, "machine_fault", fault.ID, nil, gin.H{"resolved_at": fault.ResolvedAt, "resolution": fault.Resolution})
+	assigner.Notify()
+	c.JSON(http.StatusOK, gin.H{"fault": fault})
+}
+
+func CreateMaintenanceWindow(c *gin.Context, machineRepo *repository.WashingMachineRepository, repo *repository.MaintenanceRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	var window model.MaintenanceWindow
+	if err := c.BindJSON(&window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !window.EndsAt.After(window.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+		return
+	}
+	machine, err := machineRepo.GetWashingMachineByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	window.ID = 0
+	window.MachineID = machine.Id
+	window.CreatedBy = currentUserID(c)
+	if err := repo.CreateWindow(&window); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditCreate, "maintenance_window", window.ID, nil, window)
+	assigner.Notify()
+	c.JSON(http.StatusCreated, gin.H{"maintenance_window": window})
+}
+
+func DeleteMaintenanceWindow(c *gin.Context, repo *repository.MaintenanceRepository, assigner *services.AssignmentService, audit *repository.AuditRepository) {
+	err := repo.DeleteWindow(c.Param("id"), c.Param("windowId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditDelete, "maintenance_window", c.Param("windowId"), gin.H{"machine_id": c.Param("id")}, nil)
+	assigner.Notify()
+	c.JSON(http.StatusNoContent, gin.H{"maintenance_window": nil})
+}
+
+func GetMaintenanceLog(c *gin.Context, machineRepo *repository.WashingMachineRepository, repo *repository.MaintenanceRepository) {
+	machine, err := machineRepo.GetWashingMachineByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	maintenanceLog, err := repo.GetMaintenanceLog(machine)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, maintenanceLog)
+}
+
+func IngestTelemetry(c *gin.Context, telemetry *services.TelemetryService) {
+	machineId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, services.TelemetryMaxFrameSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	event, err := telemetry.Ingest(machineId, payload, c.GetHeader("X-Telemetry-Signature"))
+	switch {
+	case errors.Is(err, services.ErrInvalidTelemetrySignature), errors.Is(err, services.ErrStaleTelemetry),
+		errors.Is(err, services.ErrReplayedTelemetry):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownTelemetryEvent), errors.Is(err, services.ErrInvalidTelemetryEvent):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"event": event})
+	}
+}
+
+func GetMachineTelemetryKey(c *gin.Context, repo *repository.WashingMachineRepository, telemetry *services.TelemetryService) {
+	machine, err := repo.GetWashingMachineByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if len(telemetry.Key) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "telemetry key is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"machine_id": machine.Id, "device_key": hex.EncodeToString(auth.DeviceKey(telemetry.Key, machine.Id))})
+}
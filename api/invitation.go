@@ -0,0 +1,126 @@
+package api
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	services "LavanderiaBackend/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// CreateInvitation invites a staff member. The token is only ever returned here, it is up to the
+// inviter to send it to the invitee.
+func CreateInvitation(c *gin.Context, invitationRepo *repository.InvitationRepository, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
+	var invitation model.Invitation
+	if err := c.BindJSON(&invitation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkGrantableRole(c, roleRepo, invitation.Role) {
+		return
+	}
+
+	token, tokenHash, err := auth.NewOneTimeToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invitation = model.Invitation{
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		TokenHash: tokenHash,
+		InvitedBy: *currentUserID(c),
+		ExpiresAt: time.Now().Add(auth.InvitationTTL),
+	}
+	if err := invitationRepo.CreateInvitation(&invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditCreate, "invitation", invitation.Id, nil, invitation)
+	c.JSON(http.StatusCreated, gin.H{"invitation": invitation, "token": token})
+}
+
+func GetAllInvitations(c *gin.Context, invitationRepo *repository.InvitationRepository) {
+	query, ok := listQuery(c)
+	if !ok {
+		return
+	}
+	invitations, err := invitationRepo.GetAllInvitations(query)
+	if err != nil {
+		c.JSON(listErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+func RevokeInvitation(c *gin.Context, invitationRepo *repository.InvitationRepository, audit *repository.AuditRepository) {
+	err := invitationRepo.RevokeInvitation(c.Param("id"))
+	if errors.Is(err, repository.ErrInvalidInvitation) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditDelete, "invitation", c.Param("id"), nil, nil)
+	c.JSON(http.StatusNoContent, gin.H{"invitation": nil})
+}
+
+// AcceptInvitation creates the invited staff account and logs it in, or hands out a two-factor
+// challenge when the role requires it.
+func AcceptInvitation(c *gin.Context, invitationRepo *repository.InvitationRepository, sessionRepo *repository.SessionRepository, accounts *services.AccountService, twoFactor *services.TwoFactorService) {
+	var body struct {
+		Token    string `json:"token" binding:"required"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+
+	// The token is checked before hashing the password, so made up tokens don't cost a bcrypt each. It is
+	// checked again when accepting, in case it was used or revoked in between.
+	tokenHash := auth.HashOneTimeToken(body.Token)
+	_, err := invitationRepo.GetPendingInvitation(tokenHash)
+	if errors.Is(err, repository.ErrInvalidInvitation) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user := model.User{Username: body.Username}
+	user.Password, err = auth.HashingPassword(body.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	err = invitationRepo.AcceptInvitation(tokenHash, &user)
+	if errors.Is(err, repository.ErrInvalidInvitation) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not register user: " + err.Error()})
+		return
+	}
+	sendVerification(accounts, user)
+	if twoFactorChallenge(c, user, twoFactor) {
+		return
+	}
+
+	tokens, err := startSession(c, user, sessionRepo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	tokens["user"] = user
+	tokens["username"] = user.Username
+	c.JSON(http.StatusCreated, tokens)
+}
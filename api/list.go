@@ -0,0 +1,59 @@
+package api
+
+import (
+	"LavanderiaBackend/repository"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// listParams are the query parameters every list endpoint understands, the others are filters.
+var listParams = map[string]bool{"limit": true, "offset": true, "cursor": true, "sort": true, "q": true, "expand": true}
+
+// listQuery reads the pagination, sorting, search and filter parameters of a list request. It answers
+// the request and returns false when they are malformed.
+func listQuery(c *gin.Context) (repository.ListQuery, bool) {
+	query := repository.ListQuery{
+		Cursor:  c.Query("cursor"),
+		Sort:    c.Query("sort"),
+		Search:  c.Query("q"),
+		Expand:  expandParam(c),
+		Filters: map[string]string{},
+	}
+	for param, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if value := c.Query(param); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a number"})
+				return query, false
+			}
+			*target = parsed
+		}
+	}
+	for param, values := range c.Request.URL.Query() {
+		if !listParams[param] && len(values) > 0 {
+			query.Filters[param] = values[0]
+		}
+	}
+	return query, true
+}
+
+// expandParam reads the comma separated relations of the expand parameter.
+func expandParam(c *gin.Context) []string {
+	var expand []string
+	for _, name := range strings.Split(c.Query("expand"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			expand = append(expand, name)
+		}
+	}
+	return expand
+}
+
+func listErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrInvalidQuery) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -3,8 +3,15 @@ package api
 import (
 	"LavanderiaBackend/model"
 	"LavanderiaBackend/repository"
-	"github.com/dgrijalva/jwt-go"
+	services "LavanderiaBackend/service"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,8 +19,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(userRepo *repository.UserRepository) gin.HandlerFunc {
+// AuthMiddleware accepts either a user access token or an API key, as a bearer token or in the
+// X-API-Key header. API keys are stored in the context as "api_key" instead of "user", along with the
+// user who created them as "api_key_creator".
+func AuthMiddleware(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, apiKeyRepo *repository.APIKeyRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, userRepo, apiKeyRepo, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
@@ -25,6 +40,10 @@ func AuthMiddleware(userRepo *repository.UserRepository) gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Bearer token not found"})
 			return
 		}
+		if auth.IsAPIKey(token) {
+			authenticateAPIKey(c, userRepo, apiKeyRepo, token)
+			return
+		}
 
 		claims, err := auth.ValidateToken(token)
 		if err != nil {
@@ -32,65 +51,129 @@ func AuthMiddleware(userRepo *repository.UserRepository) gin.HandlerFunc {
 			return
 		}
 
-		// Fetch the user from the database
-		user, err := userRepo.GetUserByUsername(claims.User.Username)
+		userID, err := claims.UserID()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token subject"})
+			return
+		}
+
+		session, err := sessionRepo.GetSessionByID(claims.SessionID)
+		if err != nil || !session.IsActive() || session.UserID != userID {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked or expired"})
+			return
+		}
+
+		// Fetch the user from the database, the token only identifies it
+		user, err := userRepo.GetCachedUserByID(userID)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			return
 		}
 
-		// Store the user model in the context
+		// Store the user model and session in the context
 		c.Set("user", user)
+		c.Set("session", session)
 
 		c.Next()
 	}
 }
 
-/*func PrivilegeMiddleware(requiredPrivilege int) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
-			return
-		}
+func authenticateAPIKey(c *gin.Context, userRepo *repository.UserRepository, apiKeyRepo *repository.APIKeyRepository, apiKey string) {
+	key, err := apiKeyRepo.Authenticate(auth.HashAPIKey(apiKey))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": repository.ErrInvalidAPIKey.Error()})
+		return
+	}
+	// A key stops working with its creator, and never grants more than the creator's current role
+	creator, err := userRepo.GetCachedUserByID(key.CreatedBy)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": repository.ErrInvalidAPIKey.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set("api_key", key)
+	c.Set("api_key_creator", creator)
+	c.Next()
+}
 
-		userModel := user.(model.User)
-		if userModel.Privileges > requiredPrivilege {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
+// RequireUser refuses API keys on routes that act on the logged in user themselves.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("user"); !exists {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only available to logged in users"})
 			return
 		}
-
 		c.Next()
 	}
-}*/
+}
 
-func PrivilegeMiddleware(requiredPrivilege int) gin.HandlerFunc {
+// currentUserID returns the ID of the authenticated user, or nil when the request is not authenticated.
+func currentUserID(c *gin.Context) *uuid.UUID {
+	user, exists := c.Get("user")
+	if !exists {
+		return nil
+	}
+	id := user.(model.User).Id
+	return &id
+}
+
+// RequirePermission lets the request through only when the role of the authenticated user grants the
+// permission in the stored permission matrix, or the API key was created with it and the role of its
+// creator still grants it.
+func RequirePermission(roleRepo *repository.RoleRepository, permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
+		_, isKey := c.Get("api_key")
+		if _, exists := c.Get("user"); !exists && !isKey {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
 			return
 		}
+		allowed, err := hasPermission(c, roleRepo, permission)
+		if err != nil || !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + permission})
+			return
+		}
 
-		userModel := user.(model.User)
-		if userModel.Privileges <= requiredPrivilege {
-			c.Next()
-		} else {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
+		c.Next()
+	}
+}
+
+// hasPermission reports whether the API key or the user the request is authenticated as holds the
+// permission, for handlers that need more than the permission guarding their route.
+func hasPermission(c *gin.Context, roleRepo *repository.RoleRepository, permission string) (bool, error) {
+	if key, isKey := c.Get("api_key"); isKey {
+		apiKey := key.(model.APIKey)
+		if !apiKey.HasPermission(permission) {
+			return false, nil
 		}
+		return roleRepo.HasPermission(c.MustGet("api_key_creator").(model.User).Role, permission)
 	}
+	if user, exists := c.Get("user"); exists {
+		return roleRepo.HasPermission(user.(model.User).Role, permission)
+	}
+	return false, nil
+}
+
+// registration is what the public can send to create an account. Binding model.User directly would let
+// anyone pick their own role.
+type registration struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email"`
+	Password string `json:"password" binding:"required"`
 }
 
-func RegisterUser(c *gin.Context, userRepo *repository.UserRepository) {
-	var user model.User
-	if err := c.BindJSON(&user); err != nil {
+// RegisterUser lets anyone create a customer account. Staff accounts come from invitations.
+func RegisterUser(c *gin.Context, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, accounts *services.AccountService) {
+	var body registration
+	if err := c.BindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
 		return
 	}
 
-	// Here, you could add hashing for the password before saving
+	user := model.User{Username: body.Username, Email: body.Email, Role: model.RoleCustomer}
 	var err error
-	user.Password, err = auth.HashingPassword(user.Password)
+	user.Password, err = auth.HashingPassword(body.Password)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
@@ -102,67 +185,153 @@ func RegisterUser(c *gin.Context, userRepo *repository.UserRepository) {
 		return
 	}
 
-	// Create JWT token after successful registration
-	expirationTime := time.Now().Add(1 * time.Hour)
-	claims := &auth.Claims{
-		User: user,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
+	sendVerification(accounts, user)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(auth.JwtKey)
+	// Start a session after successful registration
+	tokens, err := startSession(c, user, sessionRepo)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
-
-	c.JSON(http.StatusCreated, gin.H{"token": tokenString, "user": user, "username": user.Username})
-}
-
-func CheckUserCredentials(username, password string, repo *repository.UserRepository) bool {
-	// Fetch user from the database by username
-	user, err := repo.GetUserByUsername(username)
-	if err != nil {
-		return false
-	}
-
-	return auth.CheckPasswordHash(password, user.Password)
+	tokens["user"] = user
+	tokens["username"] = user.Username
+	c.JSON(http.StatusCreated, tokens)
 }
 
-func LoginForUsers(c *gin.Context, userRepo *repository.UserRepository) {
-	/*var credentials struct {
+// LoginForUsers checks the credentials behind the login guard. Unknown usernames take as long as
+// wrong passwords and get the same answer, so logins don't reveal which usernames exist.
+func LoginForUsers(c *gin.Context, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, guard *services.LoginGuard, twoFactor *services.TwoFactorService) {
+	var credentials struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
-	}*/
-	var credentials model.User
+	}
 
 	if err := c.BindJSON(&credentials); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request"})
 		return
 	}
 
-	if !CheckUserCredentials(credentials.Username, credentials.Password, userRepo) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	release, answered := throttleLogin(c, guard, credentials.Username)
+	if answered {
 		return
 	}
+	defer release()
 
-	expirationTime := time.Now().Add(1 * time.Hour)
-	claims := &auth.Claims{
-		User: credentials,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	attempt := model.LoginAttempt{Username: credentials.Username, IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	user, err := userRepo.GetUserByUsername(credentials.Username)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		auth.CheckPasswordAgainstNothing(credentials.Password)
+		attempt.Reason = "unknown username"
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	case !auth.CheckPasswordHash(credentials.Password, user.Password):
+		attempt.UserID = &user.Id
+		attempt.Reason = "wrong password"
+	default:
+		// The attempt is only recorded once the second factor is checked, so a known password can't
+		// reset the throttling of two-factor guesses
+		if twoFactorChallenge(c, user, twoFactor) {
+			return
+		}
+		attempt.UserID = &user.Id
+		attempt.Success = true
+	}
+	if err := guard.Record(attempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !attempt.Success {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(auth.JwtKey)
-
+	tokens, err := startSession(c, user, sessionRepo)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	c.JSON(http.StatusOK, tokens)
+}
+
+// throttleLogin answers 429 when the login guard wants the username or IP to wait, or another attempt
+// for the username is being checked, and otherwise takes the login lock of the username. It returns true
+// when it answered the request; otherwise the caller records the attempt and then calls release.
+func throttleLogin(c *gin.Context, guard *services.LoginGuard, username string) (func(), bool) {
+	// Checked before and after taking the lock: locked out clients never get to the database lock, and
+	// the attempts recorded while waiting for it still count
+	if waitForLogin(c, guard, username) {
+		return nil, true
+	}
+	// Not bound to the request, a client hanging up must not release the lock while its attempt is checked
+	lock, err := guard.TryLock(context.Background(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, true
+	}
+	if lock == nil {
+		tooManyLogins(c, time.Second)
+		return nil, true
+	}
+	release := func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("Error releasing the login lock of %s: %v", username, err)
+		}
+	}
+	if waitForLogin(c, guard, username) {
+		release()
+		return nil, true
+	}
+	return release, false
+}
+
+// waitForLogin answers 429 and returns true when the login guard wants the username or IP to wait.
+func waitForLogin(c *gin.Context, guard *services.LoginGuard, username string) bool {
+	wait, err := guard.RetryAfter(username, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if wait > 0 {
+		tooManyLogins(c, wait)
+		return true
+	}
+	return false
+}
+
+// waitForIP answers 429 and returns true when the login guard wants the IP to wait.
+func waitForIP(c *gin.Context, guard *services.LoginGuard) bool {
+	wait, err := guard.RetryAfterIP(c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if wait > 0 {
+		tooManyLogins(c, wait)
+		return true
+	}
+	return false
+}
+
+func tooManyLogins(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later", "retry_after": seconds})
+}
+
+// GetLoginAttempts lets admins review logins, filtered by username, ip and failed=true.
+func GetLoginAttempts(c *gin.Context, attemptRepo *repository.LoginAttemptRepository) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	attempts, err := attemptRepo.GetAttempts(c.Query("username"), c.Query("ip"), c.Query("failed") == "true", limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
 }
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func GetAllRoles(c *gin.Context, roleRepo *repository.RoleRepository) {
+	roles, err := roleRepo.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func GetAllPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, model.AllPermissions)
+}
+
+func CreateRole(c *gin.Context, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
+	var body struct {
+		Name        string   `json:"name" binding:"required"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role := model.Role{Name: body.Name, Description: body.Description}
+	if err := roleRepo.CreateRole(&role, body.Permissions); err != nil {
+		c.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditCreate, "role", role.Name, nil, role)
+	c.JSON(http.StatusCreated, role)
+}
+
+func UpdateRole(c *gin.Context, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
+	var body struct {
+		Description      string `json:"description"`
+		RequireTwoFactor bool   `json:"require_two_factor"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := roleRepo.GetRoleByName(c.Param("name"))
+	if err != nil {
+		c.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	role := model.Role{Name: existing.Name, Description: body.Description, RequireTwoFactor: body.RequireTwoFactor}
+	if err := roleRepo.UpdateRole(&role); err != nil {
+		c.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	role.Permissions = existing.Permissions
+	recordAudit(c, audit, model.AuditUpdate, "role", role.Name, existing, role)
+	c.JSON(http.StatusOK, role)
+}
+
+func SetRolePermissions(c *gin.Context, roleRepo *repository.RoleRepository, audit *repository.AuditRepository) {
+	var body struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := roleRepo.GetRoleByName(c.Param("name"))
+	if err != nil {
+		c.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	role, err := roleRepo.SetRolePermissions(existing.Name, body.Permissions)
+	if err != nil {
+		c.JSON(roleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditUpdate, "role", role.Name, existing, role)
+	c.JSON(http.StatusOK, role)
+}
+
+// checkGrantableRole answers the request and returns false unless role exists and the authenticated
+// user holds every permission it grants.
+func checkGrantableRole(c *gin.Context, roleRepo *repository.RoleRepository, role string) bool {
+	return checkRoleWithin(c, roleRepo, role, "Cannot grant a role with permissions you don't have")
+}
+
+// checkManageableUser answers 403 and returns false when the target's current role has permissions the
+// logged in user lacks, so nobody can take over or remove an account above their own.
+func checkManageableUser(c *gin.Context, roleRepo *repository.RoleRepository, target model.User) bool {
+	return checkRoleWithin(c, roleRepo, target.Role, "Cannot change a user whose role has permissions you don't have")
+}
+
+func checkRoleWithin(c *gin.Context, roleRepo *repository.RoleRepository, role string, forbidden string) bool {
+	granter := c.MustGet("user").(model.User)
+	allowed, err := roleRepo.CanGrant(granter.Role, role)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Unknown role " + role})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": forbidden})
+		return false
+	}
+	return true
+}
+
+func roleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrUnknownPermission), errors.Is(err, repository.ErrOwnerLockout):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
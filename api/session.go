@@ -0,0 +1,127 @@
+package api
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	services "LavanderiaBackend/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// startSession creates a session for the user and returns the access and refresh tokens for it.
+func startSession(c *gin.Context, user model.User, sessionRepo *repository.SessionRepository) (gin.H, error) {
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	session := model.Session{
+		UserID:           user.Id,
+		RefreshTokenHash: refreshHash,
+		ExpiresAt:        time.Now().Add(auth.RefreshTokenTTL),
+		UserAgent:        c.Request.UserAgent(),
+		IP:               c.ClientIP(),
+	}
+	if err := sessionRepo.CreateSession(&session); err != nil {
+		return nil, err
+	}
+	return sessionTokens(user, session, refreshToken)
+}
+
+func sessionTokens(user model.User, session model.Session, refreshToken string) (gin.H, error) {
+	accessToken, err := auth.GenerateToken(user, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":         accessToken,
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+	}, nil
+}
+
+// RefreshToken trades a refresh token for new tokens. Users whose role has come to require two-factor
+// authentication since they logged in lose their session and have to log in again to enroll.
+func RefreshToken(c *gin.Context, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, twoFactor *services.TwoFactorService) {
+	var body struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	session, err := sessionRepo.RotateRefreshToken(auth.HashRefreshToken(body.RefreshToken), refreshHash, time.Now().Add(auth.RefreshTokenTTL))
+	if errors.Is(err, repository.ErrInvalidRefreshToken) || errors.Is(err, repository.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := userRepo.GetUserByID(session.UserID.String())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.HasTwoFactor() {
+		required, err := twoFactor.Required(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if required {
+			if err := sessionRepo.RevokeSession(session.Id); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor authentication is required for your role, log in again to enroll"})
+			return
+		}
+	}
+	tokens, err := sessionTokens(user, session, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func Logout(c *gin.Context, sessionRepo *repository.SessionRepository) {
+	session := c.MustGet("session").(model.Session)
+	if err := sessionRepo.RevokeSession(session.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{"session": nil})
+}
+
+func GetUserSessions(c *gin.Context, sessionRepo *repository.SessionRepository) {
+	sessions, err := sessionRepo.GetUserSessions(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func RevokeUserSessions(c *gin.Context, sessionRepo *repository.SessionRepository, audit *repository.AuditRepository) {
+	revoked, err := sessionRepo.RevokeUserSessions(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit, model.AuditUpdate, "user", c.Param("id"), nil, gin.H{"sessions_revoked": revoked})
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+func GetJWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": auth.PublicKeys()})
+}
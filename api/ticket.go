@@ -0,0 +1,72 @@
+package api
+
+import (
+	"LavanderiaBackend/repository"
+	services "LavanderiaBackend/service"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// GetRequestTicket renders the ticket of a request and the tags of its garments, as a PDF or, with
+// format=escpos, as commands for a thermal printer. The code parameter picks code128 or qr barcodes and
+// tags=false leaves the tags out.
+func GetRequestTicket(c *gin.Context, repo *repository.RequestRepository) {
+	format := c.DefaultQuery("format", services.TicketPDF)
+	code := c.DefaultQuery("code", services.CodeCode128)
+	tags, err := strconv.ParseBool(c.DefaultQuery("tags", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags must be true or false"})
+		return
+	}
+	request, err := repo.GetRequestByID(c.Param("id"), "client", "line_items")
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ticket, err := services.RenderTicket(&request, format, code, tags)
+	if errors.Is(err, services.ErrUnsupportedTicket) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == services.TicketESCPOS {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ticket-%s.bin", request.Id))
+		c.Data(http.StatusOK, "application/octet-stream", ticket)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=ticket-%s.pdf", request.Id))
+	c.Data(http.StatusOK, "application/pdf", ticket)
+}
+
+// ScanCode looks up the request a scanned ticket or garment tag belongs to, for pickup. Tags also answer
+// the line item they were printed for.
+func ScanCode(c *gin.Context, repo *repository.RequestRepository) {
+	id, lineID, err := services.ParseScanCode(c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request, err := repo.GetRequestByID(id.String(), "client", "line_items", "services")
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No order matches this code"})
+		return
+	}
+	if lineID == 0 {
+		c.JSON(http.StatusOK, gin.H{"request": request})
+		return
+	}
+	for _, item := range request.LineItems {
+		if item.ID == lineID {
+			c.JSON(http.StatusOK, gin.H{"request": request, "line_item": item})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "No line of this order matches the tag"})
+}
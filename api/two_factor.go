@@ -0,0 +1,271 @@
+package api
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	services "LavanderiaBackend/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type twoFactorCodeBody struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrTwoFactorEnabled), errors.Is(err, services.ErrTwoFactorNotEnrolled):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// twoFactorChallenge answers a correct password with a challenge instead of a session when the user
+// has, or has to set up, two-factor authentication. It returns false when the login can go ahead.
+func twoFactorChallenge(c *gin.Context, user model.User, twoFactor *services.TwoFactorService) bool {
+	required, err := twoFactor.Required(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if !user.HasTwoFactor() && !required {
+		return false
+	}
+	challenge, err := auth.GenerateChallengeToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return true
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"two_factor_required":  true,
+		"enrollment_required":  !user.HasTwoFactor(),
+		"challenge":            challenge,
+		"challenge_expires_in": int(auth.ChallengeTTL.Seconds()),
+	})
+	return true
+}
+
+// challengedUser loads the user a two-factor challenge was issued to.
+func challengedUser(c *gin.Context, challenge string, userRepo *repository.UserRepository) (model.User, bool) {
+	userID, err := auth.ValidateChallengeToken(challenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return model.User{}, false
+	}
+	user, err := userRepo.GetUserByID(userID.String())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return model.User{}, false
+	}
+	return user, true
+}
+
+// EnrollTwoFactorAtLogin lets a user whose role requires two-factor authentication set it up with the
+// challenge they got from /login, since they can't log in to do it.
+func EnrollTwoFactorAtLogin(c *gin.Context, userRepo *repository.UserRepository, twoFactor *services.TwoFactorService) {
+	var body struct {
+		Challenge string `json:"challenge" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	user, ok := challengedUser(c, body.Challenge, userRepo)
+	if !ok {
+		return
+	}
+	secret, uri, err := twoFactor.Enroll(user)
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+// CompleteTwoFactorLogin trades a challenge and a code, or a recovery code, for a session. When the
+// user was enrolling, the code also confirms the enrollment and the recovery codes are returned.
+func CompleteTwoFactorLogin(c *gin.Context, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, guard *services.LoginGuard, twoFactor *services.TwoFactorService) {
+	var body struct {
+		Challenge    string `json:"challenge" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	user, ok := challengedUser(c, body.Challenge, userRepo)
+	if !ok {
+		return
+	}
+	release, answered := throttleLogin(c, guard, user.Username)
+	if answered {
+		return
+	}
+	defer release()
+
+	var recoveryCodes []string
+	var err error
+	if user.HasTwoFactor() {
+		err = twoFactor.Verify(user, body.Code, body.RecoveryCode)
+	} else {
+		recoveryCodes, err = twoFactor.Confirm(user, body.Code)
+	}
+	attempt := model.LoginAttempt{Username: user.Username, UserID: &user.Id, IP: c.ClientIP(), UserAgent: c.Request.UserAgent(), Success: err == nil}
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		attempt.Reason = "wrong two-factor code"
+	} else if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := guard.Record(attempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !attempt.Success {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	tokens, err := startSession(c, user, sessionRepo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	if recoveryCodes != nil {
+		tokens["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func EnrollTwoFactor(c *gin.Context, userRepo *repository.UserRepository, twoFactor *services.TwoFactorService) {
+	user, err := userRepo.GetUserByID(currentUserID(c).String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	secret, uri, err := twoFactor.Enroll(user)
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+func ConfirmTwoFactor(c *gin.Context, userRepo *repository.UserRepository, twoFactor *services.TwoFactorService) {
+	var body twoFactorCodeBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := userRepo.GetUserByID(currentUserID(c).String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	codes, err := twoFactor.Confirm(user, body.Code)
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// throttledTwoFactor runs an action that checks a two-factor code of the logged in user behind the login
+// guard and records the attempt, like /login/2fa, so a stolen session can't guess its way to turning
+// two-factor off or to new recovery codes. It answers the request and returns false when the action
+// didn't go through.
+func throttledTwoFactor(c *gin.Context, guard *services.LoginGuard, user model.User, action func() error) bool {
+	release, answered := throttleLogin(c, guard, user.Username)
+	if answered {
+		return false
+	}
+	defer release()
+	err := action()
+	if err != nil && !errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return false
+	}
+	attempt := model.LoginAttempt{Username: user.Username, UserID: &user.Id, IP: c.ClientIP(), UserAgent: c.Request.UserAgent(), Success: err == nil}
+	if err != nil {
+		attempt.Reason = "wrong two-factor code"
+	}
+	if err := guard.Record(attempt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !attempt.Success {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func DisableTwoFactor(c *gin.Context, userRepo *repository.UserRepository, guard *services.LoginGuard, twoFactor *services.TwoFactorService) {
+	var body twoFactorCodeBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := userRepo.GetUserByID(currentUserID(c).String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if !throttledTwoFactor(c, guard, user, func() error { return twoFactor.Disable(user, body.Code) }) {
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{"two_factor": nil})
+}
+
+func RegenerateRecoveryCodes(c *gin.Context, userRepo *repository.UserRepository, guard *services.LoginGuard, twoFactor *services.TwoFactorService) {
+	var body twoFactorCodeBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := userRepo.GetUserByID(currentUserID(c).String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	var codes []string
+	if !throttledTwoFactor(c, guard, user, func() error {
+		codes, err = twoFactor.RegenerateRecoveryCodes(user, body.Code)
+		return err
+	}) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// GetTwoFactorStatus tells the authenticated user whether two-factor authentication is on, required by
+// their role, and how many recovery codes they have left.
+func GetTwoFactorStatus(c *gin.Context, userRepo *repository.UserRepository, twoFactor *services.TwoFactorService) {
+	user, err := userRepo.GetUserByID(currentUserID(c).String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	required, err := twoFactor.Required(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	remaining, err := userRepo.CountRecoveryCodes(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.HasTwoFactor(),
+		"required":                 required,
+		"recovery_codes_remaining": remaining,
+	})
+}
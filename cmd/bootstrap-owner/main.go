@@ -0,0 +1,108 @@
+// Command bootstrap-owner creates the first owner account of a fresh installation. Everyone else is
+// invited from there, so it refuses to run once an owner exists unless -force is given.
+//
+//	go run ./cmd/bootstrap-owner -username admin -email admin@example.com
+//
+// When the username already exists that account is promoted to owner and gets the new password. With
+// -force -demote-others every other owner becomes a customer, which repairs databases where the role
+// migration made owners out of legacy accounts.
+//
+// The password is read from BOOTSTRAP_OWNER_PASSWORD, or from the first line of stdin when unset.
+package main
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/config"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	username := flag.String("username", "", "username of the owner")
+	email := flag.String("email", "", "email of the owner")
+	force := flag.Bool("force", false, "run even when an owner already exists")
+	demoteOthers := flag.Bool("demote-others", false, "with -force, make every other owner a customer")
+	flag.Parse()
+
+	if *username == "" || (*demoteOthers && !*force) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	password := os.Getenv("BOOTSTRAP_OWNER_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		log.Fatalf("Password must not be empty")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	db, err := repository.NewDB(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	roleRepo := repository.NewRoleRepository(db)
+	if err := roleRepo.SeedRoles(); err != nil {
+		log.Fatalf("Failed to seed roles: %v", err)
+	}
+	userRepo := repository.NewUserRepository(db)
+	owners, err := userRepo.CountUsersWithRole(model.RoleOwner)
+	if err != nil {
+		log.Fatalf("Failed to look for owners: %v", err)
+	}
+	if owners > 0 && !*force {
+		log.Fatalf("An owner already exists, invite new staff from the API instead or run with -force")
+	}
+
+	hash, err := auth.HashingPassword(password)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	user, err := userRepo.GetUserByUsername(*username)
+	switch {
+	case err == nil:
+		user.Role = model.RoleOwner
+		user.Password = hash
+		if *email != "" {
+			user.Email = *email
+		}
+		if err := userRepo.UpdateUser(&user); err != nil {
+			log.Fatalf("Failed to promote %s: %v", user.Username, err)
+		}
+		log.Printf("Promoted %s (%s) to owner", user.Username, user.Id)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user = model.User{Username: *username, Email: *email, Role: model.RoleOwner, Password: hash}
+		if err := userRepo.CreateUser(&user); err != nil {
+			log.Fatalf("Failed to create owner: %v", err)
+		}
+		log.Printf("Created owner %s (%s)", user.Username, user.Id)
+	default:
+		log.Fatalf("Failed to look up %s: %v", *username, err)
+	}
+
+	if *demoteOthers {
+		demoted, err := userRepo.ReassignRole(model.RoleOwner, model.RoleCustomer, user.Id)
+		if err != nil {
+			log.Fatalf("Failed to demote the other owners: %v", err)
+		}
+		log.Printf("Demoted %d other owners to customer", demoted)
+	}
+}
@@ -0,0 +1,191 @@
+// Command telemetry-simulator emulates machine controllers sending signed telemetry to the backend, so
+// the assignment and telemetry flow can be exercised locally without hardware.
+//
+//	go run ./cmd/telemetry-simulator -key "$TELEMETRY_KEY" -machines <id>,<id>
+package main
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	services "LavanderiaBackend/service"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type sender interface {
+	Send(machineId uuid.UUID, payload []byte, signature string) error
+}
+
+type httpSender struct {
+	baseURL string
+}
+
+func (s httpSender) Send(machineId uuid.UUID, payload []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/telemetry/"+machineId.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telemetry-Signature", signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+type tcpSender struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *tcpSender) Send(machineId uuid.UUID, payload []byte, signature string) error {
+	frame, err := json.Marshal(services.TelemetryFrame{MachineID: machineId, Event: payload, Signature: signature})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.conn.Write(append(frame, '\n')); err != nil {
+		return err
+	}
+	reply, err := s.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return fmt.Errorf("%s", reply)
+	}
+	return nil
+}
+
+type machine struct {
+	id        uuid.UUID
+	deviceKey []byte
+	out       sender
+}
+
+func (m machine) report(event model.TelemetryEvent) {
+	event.SentAt = time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[%s] %v", m.id, err)
+		return
+	}
+	if err := m.out.Send(m.id, payload, auth.SignTelemetry(m.deviceKey, payload)); err != nil {
+		log.Printf("[%s] %s rejected: %v", m.id, event.Type, err)
+		return
+	}
+	log.Printf("[%s] %s remaining=%ds %s", m.id, event.Type, event.RemainingSeconds, event.ErrorCode)
+}
+
+// run loops through load, cycle and unload until ctx is cancelled, failing a cycle now and then.
+func (m machine) run(ctx context.Context, cycle, tick time.Duration, errorRate float64) {
+	wait := func(d time.Duration) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+	for wait(time.Duration(rand.Int63n(int64(tick)))) {
+		m.report(model.TelemetryEvent{Type: model.TelemetryDoorOpened})
+		if !wait(tick) {
+			return
+		}
+		m.report(model.TelemetryEvent{Type: model.TelemetryDoorClosed})
+		m.report(model.TelemetryEvent{Type: model.TelemetryCycleStarted, RemainingSeconds: int(cycle.Seconds())})
+
+		failed := false
+		for remaining := cycle - tick; remaining > 0; remaining -= tick {
+			if !wait(tick) {
+				return
+			}
+			if rand.Float64() < errorRate {
+				m.report(model.TelemetryEvent{Type: model.TelemetryError, ErrorCode: fmt.Sprintf("E%02d", rand.Intn(20))})
+				failed = true
+				break
+			}
+			m.report(model.TelemetryEvent{Type: model.TelemetryCycleProgress, RemainingSeconds: int(remaining.Seconds())})
+		}
+		if !failed {
+			if !wait(tick) {
+				return
+			}
+			m.report(model.TelemetryEvent{Type: model.TelemetryCycleFinished})
+		}
+		m.report(model.TelemetryEvent{Type: model.TelemetryDoorOpened})
+		if !wait(tick) {
+			return
+		}
+		m.report(model.TelemetryEvent{Type: model.TelemetryDoorClosed})
+	}
+}
+
+func main() {
+	baseURL := flag.String("http", "http://localhost:7575", "backend base URL")
+	tcpAddr := flag.String("tcp", "", "send over the TCP telemetry listener at this address instead of HTTP")
+	key := flag.String("key", os.Getenv("TELEMETRY_KEY"), "server telemetry key the device keys are derived from")
+	machineList := flag.String("machines", "", "comma separated IDs of the machines to emulate")
+	cycle := flag.Duration("cycle", 2*time.Minute, "length of an emulated cycle")
+	tick := flag.Duration("tick", 10*time.Second, "interval between progress reports")
+	errorRate := flag.Float64("error-rate", 0.02, "chance of a machine error on every progress report")
+	flag.Parse()
+
+	if *key == "" || *machineList == "" || *cycle <= 0 || *tick <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var out sender = httpSender{baseURL: strings.TrimRight(*baseURL, "/")}
+	if *tcpAddr != "" {
+		conn, err := net.Dial("tcp", *tcpAddr)
+		if err != nil {
+			log.Fatalf("Failed to connect to telemetry listener: %v", err)
+		}
+		defer conn.Close()
+		out = &tcpSender{conn: conn, reader: bufio.NewReader(conn)}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, raw := range strings.Split(*machineList, ",") {
+		id, err := uuid.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			log.Fatalf("Invalid machine ID %q: %v", raw, err)
+		}
+		m := machine{id: id, deviceKey: auth.DeviceKey([]byte(*key), id), out: out}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.run(ctx, *cycle, *tick, *errorRate)
+		}()
+	}
+	log.Printf("Emulating %d machines, press Ctrl+C to stop", strings.Count(*machineList, ",")+1)
+	wg.Wait()
+}
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,18 +18,218 @@ type Config struct {
 	DBUser     string
 	DBPassword string
 	DBName     string
+	TaxRate    float64
+
+	// DBMaxOpenConns caps the connections to the database, the assigner keeps two of them for itself
+	DBMaxOpenConns int
+
+	// TrustedProxies are the addresses or CIDRs of the reverse proxies whose X-Forwarded-For header is
+	// believed. Empty trusts none and uses the address of the connection as the client IP.
+	TrustedProxies []string
+
+	AssignmentSweepInterval time.Duration
+
+	TelemetryKey     string
+	TelemetryTCPAddr string // Empty disables the TCP listener
+
+	JWTAlgorithm   string   // Default algorithm of the keys that don't name theirs: HS256, RS256 or EdDSA
+	JWTKeys        []JWTKey // Every key is accepted when verifying, only the active one signs
+	JWTActiveKeyID string
+
+	// DevMode allows settings that are only safe on a developer machine, like the log mailer
+	DevMode bool
+
+	PublicURL    string // Base of the links sent by email
+	Mailer       string // smtp, or log with DEV_MODE
+	MailFrom     string
+	MailDir      string // Where the log mailer also writes every message, empty to only log them
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// JWTKey is a token signing key identified by the kid header. Material is the secret itself for HS256
+// and the path to a PEM encoded private key for RS256 and EdDSA. Tokens are only verified with the
+// algorithm of the key they name, so keys of different algorithms can be rotated side by side.
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	Material  string
 }
 
 func LoadConfig() (*Config, error) {
+	// The .env file is optional, deployments usually set the variables themselves
 	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	taxRate, err := getFloat("TAX_RATE", 0)
+	if err != nil {
+		return nil, err
+	}
+	sweepInterval, err := getDuration("ASSIGNMENT_SWEEP_INTERVAL", 6*time.Minute)
 	if err != nil {
 		return nil, err
 	}
+	if sweepInterval <= 0 {
+		return nil, fmt.Errorf("invalid ASSIGNMENT_SWEEP_INTERVAL: must be positive")
+	}
+	maxOpenConns, err := getInt("DB_MAX_OPEN_CONNS", 25)
+	if err != nil {
+		return nil, err
+	}
+	if maxOpenConns < 4 {
+		return nil, fmt.Errorf("invalid DB_MAX_OPEN_CONNS: must be at least 4")
+	}
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	devMode, err := getBool("DEV_MODE", false)
+	if err != nil {
+		return nil, err
+	}
+	jwtAlgorithm := os.Getenv("JWT_ALGORITHM")
+	if jwtAlgorithm == "" {
+		jwtAlgorithm = "HS256"
+	}
+	jwtKeys, err := getJWTKeys("JWT_KEYS", jwtAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range jwtKeys {
+		if key.Algorithm == "HS256" && weakSecret(key.Material) && !devMode {
+			return nil, fmt.Errorf("jwt key %s is too weak to sign tokens: use a random secret of at least %d bytes, or DEV_MODE=true", key.ID, minSecretLength)
+		}
+	}
+	jwtActiveKeyID := os.Getenv("JWT_ACTIVE_KID")
+	if jwtActiveKeyID == "" && len(jwtKeys) > 0 {
+		jwtActiveKeyID = jwtKeys[0].ID
+	}
+	telemetryKey := os.Getenv("TELEMETRY_KEY")
+	if telemetryKey != "" && weakSecret(telemetryKey) && !devMode {
+		return nil, fmt.Errorf("TELEMETRY_KEY is too weak: use a random secret of at least %d bytes, or DEV_MODE=true", minSecretLength)
+	}
+	// There is no default: the log mailer writes password reset links to the logs
+	mailer := os.Getenv("MAILER")
+	if mailer == "" {
+		return nil, fmt.Errorf("MAILER is not set")
+	}
+	if mailer == "log" && !devMode {
+		return nil, fmt.Errorf("MAILER=log writes reset links to the logs and requires DEV_MODE=true")
+	}
 	return &Config{
 		DBHost:     os.Getenv("DB_HOST"),
 		DBPort:     os.Getenv("DB_PORT"),
 		DBUser:     os.Getenv("DB_USER"),
 		DBPassword: os.Getenv("DB_PASSWORD"),
 		DBName:     os.Getenv("DB_NAME"),
+		TaxRate:    taxRate,
+
+		DBMaxOpenConns: maxOpenConns,
+
+		TrustedProxies: trustedProxies,
+
+		AssignmentSweepInterval: sweepInterval,
+
+		TelemetryKey:     telemetryKey,
+		TelemetryTCPAddr: os.Getenv("TELEMETRY_TCP_ADDR"),
+
+		JWTAlgorithm:   jwtAlgorithm,
+		JWTKeys:        jwtKeys,
+		JWTActiveKeyID: jwtActiveKeyID,
+
+		DevMode: devMode,
+
+		PublicURL:    os.Getenv("PUBLIC_URL"),
+		Mailer:       mailer,
+		MailFrom:     os.Getenv("MAIL_FROM"),
+		MailDir:      os.Getenv("MAIL_DIR"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
 	}, nil
 }
+
+// minSecretLength is the shortest shared secret accepted outside dev mode, 256 bits like the HS256 hash.
+const minSecretLength = 32
+
+// weakSecret reports whether a shared secret is short enough to guess, or still the placeholder of the
+// example configuration.
+func weakSecret(secret string) bool {
+	return len(secret) < minSecretLength || strings.Contains(strings.ToLower(secret), "change_me")
+}
+
+func getFloat(key string, fallback float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// getJWTKeys parses a comma separated list of kid=material pairs, where kid:algorithm=material gives the
+// key an algorithm other than the default one.
+func getJWTKeys(key string, defaultAlgorithm string) ([]JWTKey, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, fmt.Errorf("%s is not set", key)
+	}
+	var keys []JWTKey
+	for _, pair := range strings.Split(value, ",") {
+		id, material, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		id, algorithm, named := strings.Cut(id, ":")
+		if !named {
+			algorithm = defaultAlgorithm
+		}
+		if !ok || id == "" || algorithm == "" || material == "" {
+			return nil, fmt.Errorf("invalid %s: expected kid=key or kid:algorithm=key pairs", key)
+		}
+		keys = append(keys, JWTKey{ID: id, Algorithm: algorithm, Material: material})
+	}
+	return keys, nil
+}
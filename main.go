@@ -2,11 +2,20 @@ package main
 
 import (
 	"LavanderiaBackend/api"
+	"LavanderiaBackend/api/auth"
 	"LavanderiaBackend/config"
+	"LavanderiaBackend/model"
 	"LavanderiaBackend/repository"
 	services "LavanderiaBackend/service"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -15,6 +24,10 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	if err := auth.LoadKeys(cfg); err != nil {
+		log.Fatalf("Failed to load token signing keys: %v", err)
+	}
+
 	db, err := repository.NewDB(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -26,130 +39,317 @@ func main() {
 	washingMachineRepo := repository.NewWashingMachineRepository(db)
 	requestRepo := repository.NewRequestRepository(db)
 	clientRepo := repository.NewClientRepository(db)
-	service := services.NewAssignmentService(washingMachineRepo)
+	sessionRepo := repository.NewSessionRepository(db)
+	maintenanceRepo := repository.NewMaintenanceRepository(db)
+	lockRepo := repository.NewLockRepository(db)
+	notificationRepo := repository.NewNotificationRepository(db)
+	roleRepo := repository.NewRoleRepository(db)
+	invitationRepo := repository.NewInvitationRepository(db)
+	userTokenRepo := repository.NewUserTokenRepository(db)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	if err := roleRepo.SeedRoles(); err != nil {
+		log.Fatalf("Failed to seed roles: %v", err)
+	}
+	service := services.NewAssignmentService(washingMachineRepo, maintenanceRepo, lockRepo, notificationRepo, cfg.AssignmentSweepInterval)
+	pricingService := services.NewPricingService(serviceRepo, cfg.TaxRate)
+	telemetryService := services.NewTelemetryService(washingMachineRepo, service, cfg.TelemetryKey)
+	mailer, err := services.NewMailer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+	accountService := services.NewAccountService(userRepo, userTokenRepo, mailer, cfg.PublicURL)
+	loginGuard := services.NewLoginGuard(loginAttemptRepo, lockRepo)
+	twoFactorService := services.NewTwoFactorService(userRepo, roleRepo)
+
+	can := func(permission string) gin.HandlerFunc {
+		return api.RequirePermission(roleRepo, permission)
+	}
 
 	r := gin.Default()
+	// Login throttling goes by client IP, which must not come from headers anyone can set
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	r.Use(gin.Logger())
 
-	go service.StartAssignmentProcess()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go service.StartAssignmentProcess(ctx)
+	if cfg.TelemetryTCPAddr != "" {
+		go func() {
+			if err := telemetryService.ListenTCP(ctx, cfg.TelemetryTCPAddr); err != nil {
+				log.Fatalf("Failed to listen for telemetry: %v", err)
+			}
+		}()
+	}
+
+	// Machines sign their telemetry instead of logging in
+	r.POST("/telemetry/:id", func(c *gin.Context) {
+		api.IngestTelemetry(c, telemetryService)
+	})
+
+	r.GET("/.well-known/jwks.json", api.GetJWKS)
 
 	authGroup := r.Group("/")
 	{
 		authGroup.POST("/register", func(c *gin.Context) {
-			api.RegisterUser(c, userRepo)
+			api.RegisterUser(c, userRepo, sessionRepo, accountService)
+		})
+		authGroup.POST("/invitations/accept", func(c *gin.Context) {
+			api.AcceptInvitation(c, invitationRepo, sessionRepo, accountService, twoFactorService)
+		})
+		authGroup.POST("/password/forgot", func(c *gin.Context) {
+			api.ForgotPassword(c, accountService, loginGuard)
+		})
+		authGroup.POST("/password/reset", func(c *gin.Context) {
+			api.ResetPassword(c, accountService, loginGuard)
+		})
+		authGroup.POST("/email/verify", func(c *gin.Context) {
+			api.VerifyEmail(c, accountService)
 		})
 		authGroup.POST("/login", func(c *gin.Context) {
-			api.LoginForUsers(c, userRepo)
+			api.LoginForUsers(c, userRepo, sessionRepo, loginGuard, twoFactorService)
+		})
+		authGroup.POST("/login/2fa", func(c *gin.Context) {
+			api.CompleteTwoFactorLogin(c, userRepo, sessionRepo, loginGuard, twoFactorService)
+		})
+		authGroup.POST("/login/2fa/enroll", func(c *gin.Context) {
+			api.EnrollTwoFactorAtLogin(c, userRepo, twoFactorService)
+		})
+
+		authGroup.POST("/token/refresh", func(c *gin.Context) {
+			api.RefreshToken(c, userRepo, sessionRepo, twoFactorService)
 		})
 
 		// Protected routes
-		authGroup.Use(api.AuthMiddleware(userRepo))
+		authGroup.Use(api.AuthMiddleware(userRepo, sessionRepo, apiKeyRepo))
 		{
+			authGroup.POST("/logout", api.RequireUser(), func(c *gin.Context) {
+				api.Logout(c, sessionRepo)
+			})
+			authGroup.GET("/2fa", api.RequireUser(), func(c *gin.Context) {
+				api.GetTwoFactorStatus(c, userRepo, twoFactorService)
+			})
+			authGroup.POST("/2fa/enroll", api.RequireUser(), func(c *gin.Context) {
+				api.EnrollTwoFactor(c, userRepo, twoFactorService)
+			})
+			authGroup.POST("/2fa/confirm", api.RequireUser(), func(c *gin.Context) {
+				api.ConfirmTwoFactor(c, userRepo, twoFactorService)
+			})
+			authGroup.POST("/2fa/disable", api.RequireUser(), func(c *gin.Context) {
+				api.DisableTwoFactor(c, userRepo, loginGuard, twoFactorService)
+			})
+			authGroup.POST("/2fa/recovery-codes", api.RequireUser(), func(c *gin.Context) {
+				api.RegenerateRecoveryCodes(c, userRepo, loginGuard, twoFactorService)
+			})
+			authGroup.POST("/email/verify/resend", api.RequireUser(), func(c *gin.Context) {
+				api.ResendVerification(c, userRepo, accountService)
+			})
+
 			// Users routes
-			authGroup.POST("/users", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.CreateUser(c, userRepo)
+			authGroup.POST("/users", api.RequireUser(), can(model.PermUsersCreate), func(c *gin.Context) {
+				api.CreateUser(c, userRepo, roleRepo, auditRepo)
 			})
-			authGroup.GET("/users", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/users", can(model.PermUsersRead), func(c *gin.Context) {
 				api.GetAllUsers(c, userRepo)
 			})
-			authGroup.GET("/users/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/users/:id", can(model.PermUsersRead), func(c *gin.Context) {
 				api.GetUserByID(c, userRepo)
 			})
-			authGroup.PATCH("/users/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.UpdateUser(c, userRepo)
+			authGroup.PATCH("/users/:id", api.RequireUser(), can(model.PermUsersUpdate), func(c *gin.Context) {
+				api.UpdateUser(c, userRepo, roleRepo, auditRepo)
 			})
-			authGroup.DELETE("/users/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.DeleteUser(c, userRepo)
+			authGroup.DELETE("/users/:id", api.RequireUser(), can(model.PermUsersDelete), func(c *gin.Context) {
+				api.DeleteUser(c, userRepo, roleRepo, auditRepo)
+			})
+			authGroup.GET("/users/:id/sessions", can(model.PermSessionsManage), func(c *gin.Context) {
+				api.GetUserSessions(c, sessionRepo)
+			})
+			authGroup.POST("/users/:id/sessions/revoke", can(model.PermSessionsManage), func(c *gin.Context) {
+				api.RevokeUserSessions(c, sessionRepo, auditRepo)
 			})
 
+			authGroup.GET("/audit", can(model.PermAuditRead), func(c *gin.Context) {
+				api.GetAuditLog(c, auditRepo)
+			})
+			authGroup.GET("/login-attempts", can(model.PermAuditRead), func(c *gin.Context) {
+				api.GetLoginAttempts(c, loginAttemptRepo)
+			})
+
+			// Invitations routes
+			authGroup.POST("/invitations", api.RequireUser(), can(model.PermUsersCreate), func(c *gin.Context) {
+				api.CreateInvitation(c, invitationRepo, roleRepo, auditRepo)
+			})
+			authGroup.GET("/invitations", can(model.PermUsersRead), func(c *gin.Context) {
+				api.GetAllInvitations(c, invitationRepo)
+			})
+			authGroup.DELETE("/invitations/:id", can(model.PermUsersCreate), func(c *gin.Context) {
+				api.RevokeInvitation(c, invitationRepo, auditRepo)
+			})
+
+			// API keys routes
+			authGroup.POST("/api-keys", api.RequireUser(), can(model.PermAPIKeysManage), func(c *gin.Context) {
+				api.CreateAPIKey(c, apiKeyRepo, roleRepo, auditRepo)
+			})
+			authGroup.GET("/api-keys", can(model.PermAPIKeysManage), func(c *gin.Context) {
+				api.GetAllAPIKeys(c, apiKeyRepo)
+			})
+			authGroup.DELETE("/api-keys/:id", can(model.PermAPIKeysManage), func(c *gin.Context) {
+				api.RevokeAPIKey(c, apiKeyRepo, auditRepo)
+			})
+
+			// Roles routes
+			authGroup.GET("/roles", can(model.PermRolesManage), func(c *gin.Context) {
+				api.GetAllRoles(c, roleRepo)
+			})
+			authGroup.POST("/roles", can(model.PermRolesManage), func(c *gin.Context) {
+				api.CreateRole(c, roleRepo, auditRepo)
+			})
+			authGroup.PATCH("/roles/:name", can(model.PermRolesManage), func(c *gin.Context) {
+				api.UpdateRole(c, roleRepo, auditRepo)
+			})
+			authGroup.PUT("/roles/:name/permissions", can(model.PermRolesManage), func(c *gin.Context) {
+				api.SetRolePermissions(c, roleRepo, auditRepo)
+			})
+			authGroup.GET("/permissions", can(model.PermRolesManage), api.GetAllPermissions)
+
 			// Product routes
-			authGroup.POST("/products", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.CreateProduct(c, productRepo)
+			authGroup.POST("/products", can(model.PermProductsCreate), func(c *gin.Context) {
+				api.CreateProduct(c, productRepo, auditRepo)
 			})
-			authGroup.GET("/products", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/products", can(model.PermProductsRead), func(c *gin.Context) {
 				api.GetAllProducts(c, productRepo)
 			})
-			authGroup.GET("/products/:name", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/products/:name", can(model.PermProductsRead), func(c *gin.Context) {
 				api.GetProductByName(c, productRepo)
 			})
-			authGroup.PATCH("/products/:name", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.UpdateProduct(c, productRepo)
+			authGroup.PATCH("/products/:name", can(model.PermProductsUpdate), func(c *gin.Context) {
+				api.UpdateProduct(c, productRepo, auditRepo)
 			})
-			authGroup.DELETE("/products/:name", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.DeleteProduct(c, productRepo)
+			authGroup.DELETE("/products/:name", can(model.PermProductsDelete), func(c *gin.Context) {
+				api.DeleteProduct(c, productRepo, auditRepo)
 			})
 
 			// Requests routes
-			authGroup.POST("/requests", api.PrivilegeMiddleware(2), func(c *gin.Context) {
-				api.CreateRequest(c, requestRepo)
+			authGroup.POST("/requests", can(model.PermRequestsCreate), func(c *gin.Context) {
+				api.CreateRequest(c, requestRepo, roleRepo, pricingService, service, auditRepo)
 			})
-			authGroup.GET("/requests", api.PrivilegeMiddleware(2), func(c *gin.Context) {
+			authGroup.GET("/requests", can(model.PermRequestsRead), func(c *gin.Context) {
 				api.GetAllRequests(c, requestRepo)
 			})
-			authGroup.GET("/requests/:id", api.PrivilegeMiddleware(2), func(c *gin.Context) {
+			authGroup.GET("/requests/:id", can(model.PermRequestsRead), func(c *gin.Context) {
 				api.GetRequestByID(c, requestRepo)
 			})
-			authGroup.PATCH("/requests/:id", api.PrivilegeMiddleware(2), func(c *gin.Context) {
-				api.UpdateRequest(c, requestRepo)
+			authGroup.PATCH("/requests/:id", can(model.PermRequestsUpdate), func(c *gin.Context) {
+				api.UpdateRequest(c, requestRepo, roleRepo, pricingService, auditRepo)
 			})
-			authGroup.DELETE("/requests/:id", api.PrivilegeMiddleware(2), func(c *gin.Context) {
-				api.DeleteRequest(c, requestRepo)
+			authGroup.DELETE("/requests/:id", can(model.PermRequestsDelete), func(c *gin.Context) {
+				api.DeleteRequest(c, requestRepo, auditRepo)
+			})
+			authGroup.POST("/requests/:id/transition", can(model.PermRequestsTransition), func(c *gin.Context) {
+				api.TransitionRequest(c, requestRepo, service, auditRepo)
+			})
+			authGroup.GET("/requests/:id/history", can(model.PermRequestsRead), func(c *gin.Context) {
+				api.GetRequestStatusHistory(c, requestRepo)
+			})
+			authGroup.GET("/requests/:id/assignments", can(model.PermRequestsRead), func(c *gin.Context) {
+				api.GetRequestAssignments(c, washingMachineRepo)
+			})
+			authGroup.GET("/requests/:id/ticket", can(model.PermRequestsRead), func(c *gin.Context) {
+				api.GetRequestTicket(c, requestRepo)
+			})
+			authGroup.GET("/scan/:code", can(model.PermRequestsRead), func(c *gin.Context) {
+				api.ScanCode(c, requestRepo)
 			})
 
 			// Clients routes
-			authGroup.POST("/clients", api.PrivilegeMiddleware(1), func(c *gin.Context) {
-				api.CreateClient(c, clientRepo)
+			authGroup.POST("/clients", can(model.PermClientsCreate), func(c *gin.Context) {
+				api.CreateClient(c, clientRepo, auditRepo)
 			})
-			authGroup.GET("/clients", api.PrivilegeMiddleware(1), func(c *gin.Context) {
+			authGroup.GET("/clients", can(model.PermClientsRead), func(c *gin.Context) {
 				api.GetAllClients(c, clientRepo)
 			})
-			authGroup.GET("/clients/:id", api.PrivilegeMiddleware(1), func(c *gin.Context) {
+			authGroup.GET("/clients/:id", can(model.PermClientsRead), func(c *gin.Context) {
 				api.GetClientByID(c, clientRepo)
 			})
-			authGroup.PATCH("/clients/:id", api.PrivilegeMiddleware(1), func(c *gin.Context) {
-				api.UpdateClient(c, clientRepo)
+			authGroup.PATCH("/clients/:id", can(model.PermClientsUpdate), func(c *gin.Context) {
+				api.UpdateClient(c, clientRepo, auditRepo)
 			})
-			authGroup.DELETE("/clients/:id", api.PrivilegeMiddleware(1), func(c *gin.Context) {
-				api.DeleteClient(c, clientRepo)
+			authGroup.DELETE("/clients/:id", can(model.PermClientsDelete), func(c *gin.Context) {
+				api.DeleteClient(c, clientRepo, auditRepo)
 			})
 
 			// washingMachines routes
-			authGroup.POST("/washingMachines", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.CreateWashingMachine(c, washingMachineRepo)
+			authGroup.POST("/washingMachines", can(model.PermMachinesCreate), func(c *gin.Context) {
+				api.CreateWashingMachine(c, washingMachineRepo, service, auditRepo)
 			})
-			authGroup.GET("/washingMachines", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/washingMachines", can(model.PermMachinesRead), func(c *gin.Context) {
 				api.GetAllWashingMachines(c, washingMachineRepo)
 			})
-			authGroup.GET("/washingMachines/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/washingMachines/:id", can(model.PermMachinesRead), func(c *gin.Context) {
 				api.GetWashingMachineByID(c, washingMachineRepo)
 			})
-			authGroup.PATCH("/washingMachines/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.UpdateWashingMachine(c, washingMachineRepo)
+			authGroup.PATCH("/washingMachines/:id", can(model.PermMachinesUpdate), func(c *gin.Context) {
+				api.UpdateWashingMachine(c, washingMachineRepo, service, auditRepo)
+			})
+			authGroup.DELETE("/washingMachines/:id", can(model.PermMachinesDelete), func(c *gin.Context) {
+				api.DeleteWashingMachine(c, washingMachineRepo, auditRepo)
+			})
+			authGroup.POST("/washingMachines/:id/cycle", can(model.PermMachinesOperate), func(c *gin.Context) {
+				api.FinishMachineCycle(c, service, auditRepo)
 			})
-			authGroup.DELETE("/washingMachines/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.DeleteWashingMachine(c, washingMachineRepo)
+			authGroup.POST("/washingMachines/:id/faults", can(model.PermMachinesReportFault), func(c *gin.Context) {
+				api.ReportMachineFault(c, washingMachineRepo, service, auditRepo)
+			})
+			authGroup.POST("/washingMachines/:id/faults/:faultId/resolve", can(model.PermMachinesOperate), func(c *gin.Context) {
+				api.ResolveMachineFault(c, maintenanceRepo, service, auditRepo)
+			})
+			authGroup.POST("/washingMachines/:id/maintenance", can(model.PermMachinesOperate), func(c *gin.Context) {
+				api.CreateMaintenanceWindow(c, washingMachineRepo, maintenanceRepo, service, auditRepo)
+			})
+			authGroup.DELETE("/washingMachines/:id/maintenance/:windowId", can(model.PermMachinesOperate), func(c *gin.Context) {
+				api.DeleteMaintenanceWindow(c, maintenanceRepo, service, auditRepo)
+			})
+			authGroup.GET("/washingMachines/:id/maintenance", can(model.PermMachinesRead), func(c *gin.Context) {
+				api.GetMaintenanceLog(c, washingMachineRepo, maintenanceRepo)
+			})
+			authGroup.GET("/washingMachines/:id/telemetry-key", can(model.PermMachinesUpdate), func(c *gin.Context) {
+				api.GetMachineTelemetryKey(c, washingMachineRepo, telemetryService)
 			})
 
 			// Services routes
-			authGroup.POST("/services", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.CreateService(c, serviceRepo)
+			authGroup.POST("/services", can(model.PermServicesCreate), func(c *gin.Context) {
+				api.CreateService(c, serviceRepo, auditRepo)
 			})
-			authGroup.GET("/services", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/services", can(model.PermServicesRead), func(c *gin.Context) {
 				api.GetAllServices(c, serviceRepo)
 			})
-			authGroup.GET("/services/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
+			authGroup.GET("/services/:id", can(model.PermServicesRead), func(c *gin.Context) {
 				api.GetServiceByID(c, serviceRepo)
 			})
-			authGroup.PATCH("/services/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.UpdateService(c, serviceRepo)
+			authGroup.PATCH("/services/:id", can(model.PermServicesUpdate), func(c *gin.Context) {
+				api.UpdateService(c, serviceRepo, auditRepo)
 			})
-			authGroup.DELETE("/services/:id", api.PrivilegeMiddleware(0), func(c *gin.Context) {
-				api.DeleteService(c, serviceRepo)
+			authGroup.DELETE("/services/:id", can(model.PermServicesDelete), func(c *gin.Context) {
+				api.DeleteService(c, serviceRepo, auditRepo)
 			})
 		}
 	}
 
-	if err := r.Run(":7575"); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	server := &http.Server{Addr: ":7575", Handler: r}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to run server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
 	}
 }
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// APIKey authenticates an integration, like the payment kiosk, without a user login. It only grants
+// the permissions it was created with that the role of its creator still has, and stops working when the
+// creator is deleted. Only the hash of the key is stored.
+type APIKey struct {
+	Id          uuid.UUID     `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Name        string        `json:"name"`
+	Prefix      string        `json:"prefix"` // Start of the key, to tell keys apart
+	KeyHash     string        `gorm:"uniqueIndex" json:"-"`
+	Permissions []*Permission `gorm:"many2many:api_key_permissions;" json:"permissions"`
+	CreatedBy   uuid.UUID     `gorm:"type:uuid" json:"created_by"`
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"` // Nil never expires
+	LastUsedAt  *time.Time    `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time    `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+func (k *APIKey) IsActive() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+func (k *APIKey) HasPermission(permission string) bool {
+	for _, p := range k.Permissions {
+		if p.Name == permission {
+			return true
+		}
+	}
+	return false
+}
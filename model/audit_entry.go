@@ -0,0 +1,46 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+)
+
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+)
+
+// AuditEntry records one change made through the API. Entries are never updated or deleted, the
+// database refuses it.
+type AuditEntry struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	ActorType  string          `json:"actor_type"`
+	ActorID    *uuid.UUID      `gorm:"type:uuid;index" json:"actor_id,omitempty"`
+	ActorName  string          `json:"actor_name"`
+	Action     AuditAction     `gorm:"index" json:"action"`
+	EntityType string          `gorm:"index:idx_audit_entity" json:"entity_type"`
+	EntityID   string          `gorm:"index:idx_audit_entity" json:"entity_id"`
+	Changes    json.RawMessage `gorm:"type:jsonb" json:"changes"` // Field name to {"before", "after"}
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `gorm:"index" json:"created_at"`
+}
+
+// AuditFilter narrows down an audit log query. Zero fields don't filter.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	EntityType string
+	EntityID   string
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Invitation lets someone create a staff account with the given role. Only the hash of the token is
+// stored, the token itself is shown once to the inviter who passes it on.
+type Invitation struct {
+	Id         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Email      string     `gorm:"index" json:"email" binding:"required"`
+	Role       string     `json:"role" binding:"required"`
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	InvitedBy  uuid.UUID  `gorm:"type:uuid" json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy *uuid.UUID `gorm:"type:uuid" json:"accepted_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (i *Invitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && time.Now().Before(i.ExpiresAt)
+}
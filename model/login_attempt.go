@@ -0,0 +1,19 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// LoginAttempt records every try to log in. Failed attempts drive the login throttling and are kept
+// for admins to review.
+type LoginAttempt struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Username  string     `gorm:"index" json:"username"`
+	UserID    *uuid.UUID `gorm:"type:uuid" json:"user_id,omitempty"` // Nil when the username doesn't exist
+	IP        string     `gorm:"index" json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	Success   bool       `json:"success"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+}
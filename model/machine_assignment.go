@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type Stage string
+
+const (
+	StageWash      Stage = "wash"
+	StageDry       Stage = "dry"
+	StageFullCycle Stage = "full_cycle" // Wash and dry in one go on a combo machine
+)
+
+type CycleOutcome string
+
+const (
+	CycleCompleted     CycleOutcome = "completed"      // Ran for the expected time
+	CycleFinishedEarly CycleOutcome = "finished_early" // Marked as done by an operator before the expected end
+	CycleFailed        CycleOutcome = "failed"         // Stopped by an operator, the load has to be run again
+)
+
+// MachineAssignment records which machine was picked for (part of) a request load and why.
+type MachineAssignment struct {
+	ID            uint         `gorm:"primaryKey" json:"id"`
+	RequestID     uuid.UUID    `gorm:"type:uuid;index" json:"request_id"`
+	MachineID     uuid.UUID    `gorm:"type:uuid;index" json:"machine_id"`
+	Stage         Stage        `json:"stage"`
+	LoadWeight    float64      `json:"load_weight"`
+	Reason        string       `json:"reason"`
+	CreatedAt     time.Time    `json:"created_at"`
+	ExpectedEndAt time.Time    `gorm:"index" json:"expected_end_at"`
+	FinishedAt    *time.Time   `json:"finished_at,omitempty"` // Null while the machine is still running the load
+	Outcome       CycleOutcome `json:"outcome,omitempty"`
+	Note          string       `json:"note,omitempty"`
+}
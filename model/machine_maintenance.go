@@ -0,0 +1,38 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type MachineFault struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	MachineID   uuid.UUID  `gorm:"type:uuid;index" json:"machine_id"`
+	Description string     `json:"description"`
+	ReportedBy  *uuid.UUID `gorm:"type:uuid" json:"reported_by,omitempty"`
+	CreatedAt   time.Time  `json:"reported_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Resolution  string     `json:"resolution,omitempty"`
+}
+
+// MaintenanceWindow takes a machine out of rotation between StartsAt and EndsAt.
+type MaintenanceWindow struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	MachineID uuid.UUID  `gorm:"type:uuid;index" json:"machine_id"`
+	StartsAt  time.Time  `json:"starts_at" binding:"required"`
+	EndsAt    time.Time  `json:"ends_at" binding:"required"`
+	Reason    string     `json:"reason"`
+	CreatedBy *uuid.UUID `gorm:"type:uuid" json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MaintenanceLog summarises the downtime and usage of a machine.
+type MaintenanceLog struct {
+	MachineID       uuid.UUID           `json:"machine_id"`
+	Status          MachineStatus       `json:"status"`
+	CycleCount      int64               `json:"cycle_count"`
+	FailedCycles    int64               `json:"failed_cycles"`
+	DowntimeMinutes float64             `json:"downtime_minutes"`
+	Faults          []MachineFault      `json:"faults"`
+	Windows         []MaintenanceWindow `json:"maintenance_windows"`
+}
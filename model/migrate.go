@@ -2,6 +2,59 @@ package model
 
 import "gorm.io/gorm"
 
+// auditAppendOnly makes the database itself refuse to change or remove audit entries.
+const auditAppendOnly = `
+CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit entries are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries;
+CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_entries
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_entries_append_only();
+`
+
+// fullCycleBackfill undoes the default is_full_cycle used to have, which made every service a full cycle
+// since GORM leaves false out of inserts when a column has a default. Services that only wash or only dry
+// go back to being partial, and so do the ones that do neither, like ironing; a full cycle service made
+// back then looks the same and has to be marked again. It runs once, while the column still has its
+// default.
+const fullCycleBackfill = `
+UPDATE services SET is_full_cycle = false
+	WHERE is_full_cycle AND (is_washing <> is_drying OR (NOT is_washing AND NOT is_drying));
+ALTER TABLE services ALTER COLUMN is_full_cycle DROP DEFAULT;
+`
+
+// requestStatusBackfill gives requests from before the status column the status their legacy flags
+// stand for, instead of the received default. Fulfilled orders were delivered. Ongoing ones were being
+// worked on without their machine cycles being tracked, so they wait for staff to finish them by hand
+// rather than being washed again. A received request never has either flag set otherwise, so running it
+// again changes nothing.
+const requestStatusBackfill = `
+UPDATE requests SET status = CASE WHEN fulfilled THEN 'delivered' ELSE 'folding' END, ongoing = NOT fulfilled
+	WHERE status = 'received' AND (fulfilled OR ongoing);
+`
+
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(&User{}, &WashingMachine{}, &Client{}, &Request{}, &Service{}, &Product{})
+	var fullCycleDefault *string
+	err := db.Raw("SELECT column_default FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'services' AND column_name = 'is_full_cycle'").
+		Scan(&fullCycleDefault).Error
+	if err != nil {
+		return err
+	}
+	if fullCycleDefault != nil {
+		if err := db.Exec(fullCycleBackfill).Error; err != nil {
+			return err
+		}
+	}
+
+	err = db.AutoMigrate(&User{}, &WashingMachine{}, &Client{}, &Request{}, &Service{}, &Product{}, &RequestStatusHistory{}, &RequestLineItem{}, &MachineAssignment{}, &MachineFault{}, &MaintenanceWindow{}, &Session{}, &Permission{}, &Role{}, &Invitation{}, &UserToken{}, &LoginAttempt{}, &RecoveryCode{}, &APIKey{}, &AuditEntry{})
+	if err != nil {
+		return err
+	}
+	if err := db.Exec(requestStatusBackfill).Error; err != nil {
+		return err
+	}
+	return db.Exec(auditAppendOnly).Error
 }
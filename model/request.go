@@ -8,17 +8,35 @@ import (
 
 type Request struct {
 	gorm.Model
-	Id               uuid.UUID       `gorm:"type:uuid;default:uuid_generate_v4();primaryKey;uniqueIndex" json:"id"`
-	OrderedDate      time.Time       `json:"ordered_date"`
-	FulfilledDate    time.Time       `json:"fulfilled_date"`
-	Services         []*Service      `gorm:"many2many:request_services;" json:"services"`
-	Fulfilled        bool            `json:"fulfilled"`
-	Ongoing          bool            `json:"ongoing"`
-	WashingMachineID *uuid.UUID      `gorm:"type:uuid;default:null" json:"washing_machine_id,omitempty"`   // Pointer to allow null
-	WashingMachine   *WashingMachine `gorm:"foreignKey:WashingMachineID" json:"washing_machine,omitempty"` // Pointer to allow null
-	GrandTotal       float64         `json:"grand_total"`
-	Client           Client          `gorm:"foreignKey:ClientID" json:"client"`
-	ClientID         uuid.UUID       `gorm:"type:uuid" json:"client_id"`
+	Id               uuid.UUID              `gorm:"type:uuid;default:uuid_generate_v4();primaryKey;uniqueIndex" json:"id"`
+	OrderedDate      time.Time              `json:"ordered_date"`
+	FulfilledDate    time.Time              `json:"fulfilled_date"`
+	Services         []*Service             `gorm:"many2many:request_services;" json:"services"`
+	Status           RequestStatus          `gorm:"default:received;index" json:"status"`
+	Fulfilled        bool                   `json:"fulfilled"`                                                    // Derived from Status, kept for older clients
+	Ongoing          bool                   `json:"ongoing"`                                                      // Derived from Status, kept for older clients
+	WashingMachineID *uuid.UUID             `gorm:"type:uuid;default:null" json:"washing_machine_id,omitempty"`   // Pointer to allow null
+	WashingMachine   *WashingMachine        `gorm:"foreignKey:WashingMachineID" json:"washing_machine,omitempty"` // Pointer to allow null
+	LoadWeight       float64                `json:"load_weight"`                                                  // In kg, the sum of the line weights when they have one
+	LineItems        []RequestLineItem      `gorm:"foreignKey:RequestID" json:"line_items"`
+	DiscountPercent  float64                `json:"discount_percent"`
+	Subtotal         float64                `json:"subtotal"`       // Calculated by the server
+	DiscountTotal    float64                `json:"discount_total"` // Calculated by the server
+	TaxTotal         float64                `json:"tax_total"`      // Calculated by the server
+	GrandTotal       float64                `json:"grand_total"`    // Calculated by the server
+	Client           Client                 `gorm:"foreignKey:ClientID" json:"client"`
+	ClientID         uuid.UUID              `gorm:"type:uuid" json:"client_id"`
+	StatusHistory    []RequestStatusHistory `gorm:"foreignKey:RequestID" json:"status_history,omitempty"`
+}
+
+// ApplyStatus sets the status and keeps the legacy Fulfilled and Ongoing flags consistent with it.
+func (r *Request) ApplyStatus(status RequestStatus) {
+	r.Status = status
+	r.Fulfilled = status == StatusDelivered
+	r.Ongoing = !status.IsFinal() && status != StatusReceived
+	if status == StatusDelivered {
+		r.FulfilledDate = time.Now()
+	}
 }
 
 func (r *Request) RequiresWashing() bool {
@@ -29,3 +47,44 @@ func (r *Request) RequiresWashing() bool {
 	}
 	return false
 }
+
+// StageDuration returns how long the machines are expected to be busy with the stage of this request.
+// Services run together in the same load, so the longest one decides.
+func (r *Request) StageDuration(stage Stage) time.Duration {
+	var longest time.Duration
+	for _, service := range r.Services {
+		if d := service.StageDuration(stage); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+func (r *Request) NeedsWashing() bool {
+	for _, service := range r.Services {
+		if service.NeedsWashing() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Request) NeedsDrying() bool {
+	for _, service := range r.Services {
+		if service.NeedsDrying() {
+			return true
+		}
+	}
+	return false
+}
+
+// LineItemsEditable reports whether the garments and services of the request may still change, which is
+// until it goes into a machine.
+func (r *Request) LineItemsEditable() bool {
+	return r.Status == StatusReceived || r.Status == StatusQueued
+}
+
+// HasClientTotals reports whether any of the server calculated amounts were sent by the client.
+func (r *Request) HasClientTotals() bool {
+	return r.Subtotal != 0 || r.DiscountTotal != 0 || r.TaxTotal != 0 || r.GrandTotal != 0 || len(r.LineItems) > 0
+}
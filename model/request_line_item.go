@@ -0,0 +1,34 @@
+package model
+
+import (
+	"errors"
+	"github.com/google/uuid"
+)
+
+var ErrLineItemsLocked = errors.New("line items cannot change once the request has started washing")
+
+// RequestLineItem is one line of an order: a service applied to some garments. Services priced per kg
+// are charged on the weight of the line, the others on its quantity.
+type RequestLineItem struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	RequestID    uuid.UUID `gorm:"type:uuid;index" json:"request_id"`
+	ServiceID    int       `json:"service_id"`
+	Description  string    `json:"description"`
+	GarmentType  string    `json:"garment_type,omitempty"` // Shirt, duvet, curtain...
+	Quantity     float64   `json:"quantity"`
+	Weight       float64   `json:"weight,omitempty"` // In kg
+	Instructions string    `json:"instructions,omitempty"`
+	UnitPrice    float64   `json:"unit_price"`
+	Total        float64   `json:"total"`
+}
+
+// Order returns what was ordered on the line, to price it again.
+func (item *RequestLineItem) Order() ServiceOrder {
+	return ServiceOrder{
+		ServiceID:    item.ServiceID,
+		GarmentType:  item.GarmentType,
+		Quantity:     item.Quantity,
+		Weight:       item.Weight,
+		Instructions: item.Instructions,
+	}
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid status transition")
+
+type RequestStatus string
+
+const (
+	StatusReceived       RequestStatus = "received"
+	StatusQueued         RequestStatus = "queued"
+	StatusWashing        RequestStatus = "washing"
+	StatusDrying         RequestStatus = "drying"
+	StatusFolding        RequestStatus = "folding"
+	StatusReadyForPickup RequestStatus = "ready_for_pickup"
+	StatusDelivered      RequestStatus = "delivered"
+	StatusCancelled      RequestStatus = "cancelled"
+)
+
+// requestTransitions lists, for every status, the statuses a request may move to next.
+var requestTransitions = map[RequestStatus][]RequestStatus{
+	StatusReceived:       {StatusQueued, StatusCancelled},
+	StatusQueued:         {StatusWashing, StatusDrying, StatusFolding, StatusCancelled},
+	StatusWashing:        {StatusQueued, StatusDrying, StatusFolding, StatusCancelled},
+	StatusDrying:         {StatusFolding, StatusCancelled},
+	StatusFolding:        {StatusReadyForPickup, StatusCancelled},
+	StatusReadyForPickup: {StatusDelivered, StatusCancelled},
+	StatusDelivered:      {},
+	StatusCancelled:      {},
+}
+
+func (s RequestStatus) IsValid() bool {
+	_, ok := requestTransitions[s]
+	return ok
+}
+
+func (s RequestStatus) CanTransitionTo(next RequestStatus) bool {
+	for _, allowed := range requestTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s RequestStatus) IsFinal() bool {
+	return s == StatusDelivered || s == StatusCancelled
+}
+
+type RequestStatusHistory struct {
+	ID         uint          `gorm:"primaryKey" json:"id"`
+	RequestID  uuid.UUID     `gorm:"type:uuid;index" json:"request_id"`
+	FromStatus RequestStatus `json:"from_status"`
+	ToStatus   RequestStatus `json:"to_status"`
+	ChangedBy  *uuid.UUID    `gorm:"type:uuid" json:"changed_by,omitempty"` // Null when changed by the system
+	Note       string        `json:"note,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
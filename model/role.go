@@ -0,0 +1,112 @@
+package model
+
+const (
+	RoleOwner    = "owner"
+	RoleManager  = "manager"
+	RoleCashier  = "cashier"
+	RoleOperator = "operator"
+	RoleCustomer = "customer"
+)
+
+const (
+	PermUsersRead      = "users:read"
+	PermUsersCreate    = "users:create"
+	PermUsersUpdate    = "users:update"
+	PermUsersDelete    = "users:delete"
+	PermSessionsManage = "sessions:manage"
+	PermRolesManage    = "roles:manage"
+	PermAuditRead      = "audit:read"
+	PermAPIKeysManage  = "api_keys:manage"
+
+	PermProductsRead   = "products:read"
+	PermProductsCreate = "products:create"
+	PermProductsUpdate = "products:update"
+	PermProductsDelete = "products:delete"
+
+	PermRequestsRead       = "requests:read"
+	PermRequestsCreate     = "requests:create"
+	PermRequestsUpdate     = "requests:update"
+	PermRequestsDelete     = "requests:delete"
+	PermRequestsTransition = "requests:transition"
+	PermRequestsDiscount   = "requests:discount" // Set discount_percent on a request
+
+	PermClientsRead   = "clients:read"
+	PermClientsCreate = "clients:create"
+	PermClientsUpdate = "clients:update"
+	PermClientsDelete = "clients:delete"
+
+	PermMachinesRead        = "machines:read"
+	PermMachinesCreate      = "machines:create"
+	PermMachinesUpdate      = "machines:update"
+	PermMachinesDelete      = "machines:delete"
+	PermMachinesOperate     = "machines:operate" // End cycles, resolve faults and plan maintenance
+	PermMachinesReportFault = "machines:report_fault"
+
+	PermServicesRead   = "services:read"
+	PermServicesCreate = "services:create"
+	PermServicesUpdate = "services:update"
+	PermServicesDelete = "services:delete"
+)
+
+// AllPermissions lists every permission the API checks.
+var AllPermissions = []string{
+	PermUsersRead, PermUsersCreate, PermUsersUpdate, PermUsersDelete, PermSessionsManage, PermRolesManage, PermAuditRead, PermAPIKeysManage,
+	PermProductsRead, PermProductsCreate, PermProductsUpdate, PermProductsDelete,
+	PermRequestsRead, PermRequestsCreate, PermRequestsUpdate, PermRequestsDelete, PermRequestsTransition, PermRequestsDiscount,
+	PermClientsRead, PermClientsCreate, PermClientsUpdate, PermClientsDelete,
+	PermMachinesRead, PermMachinesCreate, PermMachinesUpdate, PermMachinesDelete, PermMachinesOperate, PermMachinesReportFault,
+	PermServicesRead, PermServicesCreate, PermServicesUpdate, PermServicesDelete,
+}
+
+// DefaultRolePermissions is the permission matrix the built-in roles are created with. Owners can
+// change it afterwards, and the stored matrix is what is enforced.
+var DefaultRolePermissions = map[string][]string{
+	RoleOwner: AllPermissions,
+	RoleManager: {
+		PermUsersRead,
+		PermProductsRead, PermProductsCreate, PermProductsUpdate, PermProductsDelete,
+		PermRequestsRead, PermRequestsCreate, PermRequestsUpdate, PermRequestsDelete, PermRequestsTransition, PermRequestsDiscount,
+		PermClientsRead, PermClientsCreate, PermClientsUpdate, PermClientsDelete,
+		PermMachinesRead, PermMachinesOperate, PermMachinesReportFault,
+		PermServicesRead, PermServicesCreate, PermServicesUpdate,
+	},
+	RoleCashier: {
+		PermProductsRead,
+		PermRequestsRead, PermRequestsCreate, PermRequestsTransition,
+		PermClientsRead, PermClientsCreate, PermClientsUpdate,
+		PermServicesRead,
+	},
+	RoleOperator: {
+		PermRequestsRead, PermRequestsTransition,
+		PermMachinesRead, PermMachinesOperate, PermMachinesReportFault,
+	},
+	RoleCustomer: {},
+}
+
+// LegacyPrivilegeRoles maps the numeric privilege levels used before roles existed. Level 0 is left out
+// on purpose: self-registered accounts were stored with it too, so those users become customers and the
+// real owner has to be named with cmd/bootstrap-owner.
+var LegacyPrivilegeRoles = map[int]string{
+	1: RoleManager,
+	2: RoleCashier,
+}
+
+type Permission struct {
+	Name string `gorm:"primaryKey" json:"name"`
+}
+
+type Role struct {
+	Name        string `gorm:"primaryKey" json:"name" binding:"required"`
+	Description string `json:"description,omitempty"`
+	// RequireTwoFactor makes users with the role enroll in two-factor authentication before they can log in
+	RequireTwoFactor bool          `json:"require_two_factor"`
+	Permissions      []*Permission `gorm:"many2many:role_permissions;" json:"permissions"`
+}
+
+func (r *Role) PermissionNames() []string {
+	names := make([]string, 0, len(r.Permissions))
+	for _, permission := range r.Permissions {
+		names = append(names, permission.Name)
+	}
+	return names
+}
@@ -1,14 +1,60 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Default program lengths for services that don't set their own.
+const (
+	DefaultWashingDuration = 40 * time.Minute
+	DefaultDryingDuration  = 50 * time.Minute
+)
 
 type Service struct {
-	gorm.Model  `json:"gorm_._model"`
-	Id          int        `gorm:"primaryKey" json:"id" json:"id,omitempty"`
-	Name        string     `json:"name,omitempty" json:"name,omitempty"`
-	Price       float64    `json:"price,omitempty" json:"price,omitempty"`
-	IsWashing   bool       `gorm:"default:false" json:"isWashing,omitempty"`
-	IsDrying    bool       `gorm:"default:false" json:"isDrying,omitempty"`
-	IsFullCycle bool       `gorm:"default:true" json:"isFullCycle,omitempty"`
-	Products    []*Product `gorm:"many2many:service_products;" json:"products,omitempty" json:"products,omitempty"`
+	gorm.Model   `json:"gorm_._model"`
+	Id           int        `gorm:"primaryKey" json:"id" json:"id,omitempty"`
+	Name         string     `json:"name,omitempty" json:"name,omitempty"`
+	Price        float64    `json:"price,omitempty" json:"price,omitempty"`
+	IsWashing    bool       `gorm:"default:false" json:"isWashing,omitempty"`
+	IsDrying     bool       `gorm:"default:false" json:"isDrying,omitempty"`
+	IsFullCycle  bool       `json:"isFullCycle,omitempty"`
+	PricedPerKg  bool       `gorm:"default:false" json:"pricedPerKg,omitempty"`
+	CycleMinutes int        `json:"cycleMinutes,omitempty"`                  // Program length, the defaults above are used when zero
+	Inactive     bool       `gorm:"default:false" json:"inactive,omitempty"` // Stays on past orders but can't be ordered anymore
+	Products     []*Product `gorm:"many2many:service_products;" json:"products,omitempty" json:"products,omitempty"`
+}
+
+func (s *Service) NeedsWashing() bool {
+	return s.IsWashing || s.IsFullCycle
+}
+
+func (s *Service) NeedsDrying() bool {
+	return s.IsDrying || s.IsFullCycle
+}
+
+// StageDuration returns how long a machine is busy running this service's part of the stage, or zero
+// when the service has nothing to do in it. CycleMinutes overrides the default length of the whole program.
+func (s *Service) StageDuration(stage Stage) time.Duration {
+	var wash, dry time.Duration
+	if s.NeedsWashing() {
+		wash = DefaultWashingDuration
+	}
+	if s.NeedsDrying() {
+		dry = DefaultDryingDuration
+	}
+	if s.CycleMinutes > 0 && wash+dry > 0 {
+		// Split a custom program length between the stages in the same proportion as the defaults
+		custom := time.Duration(s.CycleMinutes) * time.Minute
+		share := float64(wash) / float64(wash+dry)
+		wash = time.Duration(float64(custom) * share)
+		dry = custom - wash
+	}
+	switch stage {
+	case StageWash:
+		return wash
+	case StageDry:
+		return dry
+	}
+	return wash + dry
 }
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ServiceOrder is one line of an order as sent by clients, a catalogue service applied to some garments.
+// It is written either as the bare service ID or as an object. A zero Quantity means one unit. Services
+// priced per kg are charged on Weight, or on the load weight of the request when no line has one.
+type ServiceOrder struct {
+	ServiceID    int     `json:"id"`
+	GarmentType  string  `json:"garment_type,omitempty"`
+	Quantity     float64 `json:"quantity,omitempty"`
+	Weight       float64 `json:"weight,omitempty"` // In kg
+	Instructions string  `json:"instructions,omitempty"`
+}
+
+func (o *ServiceOrder) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		*o = ServiceOrder{}
+		return json.Unmarshal(data, &o.ServiceID)
+	}
+	type serviceOrder ServiceOrder
+	return json.Unmarshal(data, (*serviceOrder)(o))
+}
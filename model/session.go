@@ -0,0 +1,25 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Session is a login of a user. Access tokens carry the session ID, and the session holds the hash of
+// the refresh token currently allowed to renew them.
+type Session struct {
+	Id                  uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID              uuid.UUID  `gorm:"type:uuid;index" json:"user_id"`
+	RefreshTokenHash    string     `gorm:"uniqueIndex" json:"-"`
+	PreviousRefreshHash string     `gorm:"index" json:"-"` // Kept to detect reuse of a rotated token
+	ExpiresAt           time.Time  `json:"expires_at"`
+	RevokedAt           *time.Time `json:"revoked_at,omitempty"`
+	UserAgent           string     `json:"user_agent"`
+	IP                  string     `json:"ip"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
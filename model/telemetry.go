@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+type TelemetryEventType string
+
+const (
+	TelemetryDoorOpened    TelemetryEventType = "door_opened"
+	TelemetryDoorClosed    TelemetryEventType = "door_closed"
+	TelemetryCycleStarted  TelemetryEventType = "cycle_started"
+	TelemetryCycleProgress TelemetryEventType = "cycle_progress"
+	TelemetryCycleFinished TelemetryEventType = "cycle_finished"
+	TelemetryError         TelemetryEventType = "error"
+)
+
+func (t TelemetryEventType) IsValid() bool {
+	switch t {
+	case TelemetryDoorOpened, TelemetryDoorClosed, TelemetryCycleStarted, TelemetryCycleProgress, TelemetryCycleFinished, TelemetryError:
+		return true
+	}
+	return false
+}
+
+// TelemetryEvent is a single report sent by a machine controller.
+type TelemetryEvent struct {
+	Type             TelemetryEventType `json:"type"`
+	RemainingSeconds int                `json:"remaining_seconds,omitempty"`
+	ErrorCode        string             `json:"error_code,omitempty"`
+	SentAt           time.Time          `json:"sent_at"`
+	Sequence         uint64             `json:"seq"` // Grows with every report of the machine, so each is accepted once
+}
@@ -1,15 +1,34 @@
 package model
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"time"
 )
 
 type User struct {
-	gorm.Model `json:"gorm_._model"`
-	Id         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();uniqueIndex;primaryKey" json:"id,omitempty"`
-	Username   string    `gorm:"uniqueIndex;unique" json:"username,omitempty"`
-	Email      string    `gorm:"uniqueIndex;unique" json:"email,omitempty"`
-	Privileges int       `json:"privileges,omitempty"`
-	Password   string    `json:"password,omitempty"`
+	gorm.Model      `json:"gorm_._model"`
+	Id              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();uniqueIndex;primaryKey" json:"id,omitempty"`
+	Username        string     `gorm:"uniqueIndex;unique" json:"username,omitempty"`
+	Email           string     `gorm:"uniqueIndex;unique" json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Role            string     `gorm:"index" json:"role,omitempty"`
+	Password        string     `json:"password,omitempty"`
+
+	TOTPSecret    string     `json:"-"` // Set on enrollment, only enforced once TOTPEnabledAt is set
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at,omitempty"`
+	TOTPLastStep  int64      `json:"-"` // Time step of the last accepted code, so no code works twice
+}
+
+// MarshalJSON leaves the password hash out of every response; it is still read from request bodies.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	safe := user(u)
+	safe.Password = ""
+	return json.Marshal(safe)
+}
+
+func (u *User) HasTwoFactor() bool {
+	return u.TOTPEnabledAt != nil
 }
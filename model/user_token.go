@@ -0,0 +1,26 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type TokenPurpose string
+
+const (
+	TokenPasswordReset     TokenPurpose = "password_reset"
+	TokenEmailVerification TokenPurpose = "email_verification"
+)
+
+// UserToken is a single use token mailed to a user to prove they own their email address. Only its
+// hash is stored.
+type UserToken struct {
+	Id        uuid.UUID    `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID    uuid.UUID    `gorm:"type:uuid;index" json:"user_id"`
+	Purpose   TokenPurpose `gorm:"index" json:"purpose"`
+	Email     string       `json:"email"` // Address the token was sent to
+	TokenHash string       `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    *time.Time   `json:"used_at,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
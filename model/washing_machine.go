@@ -3,13 +3,59 @@ package model
 import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"time"
 )
 
 type WashingMachine struct {
 	gorm.Model
-	MachineModel   string    `json:"machine_model,omitempty"`
-	Id             uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();uniqueIndex;primaryKey" json:"id,omitempty"`
-	Capacity       float64   `json:"capacity,omitempty"`
-	Occupied       bool      `json:"occupied,omitempty"`
-	CurrentRequest *Request  `gorm:"foreignKey:WashingMachineID" json:"current_request,omitempty"`
+	MachineModel     string        `json:"machine_model,omitempty"`
+	MachineType      MachineType   `gorm:"default:washer" json:"machine_type,omitempty"`
+	Id               uuid.UUID     `gorm:"type:uuid;default:uuid_generate_v4();uniqueIndex;primaryKey" json:"id,omitempty"`
+	Capacity         float64       `json:"capacity,omitempty"`
+	Status           MachineStatus `gorm:"default:available;index" json:"status,omitempty"`
+	Occupied         bool          `json:"occupied,omitempty"` // Derived from Status, kept for older clients
+	CurrentRequest   *Request      `gorm:"foreignKey:WashingMachineID" json:"current_request,omitempty"`
+	DoorOpen         bool          `json:"door_open"`                   // Last reported by telemetry
+	RemainingSeconds int           `json:"remaining_seconds,omitempty"` // Last reported by telemetry
+	ErrorCode        string        `json:"error_code,omitempty"`        // Last reported by telemetry
+	LastTelemetryAt  *time.Time    `json:"last_telemetry_at,omitempty"`
+	// TelemetrySequence is the sequence number of the last accepted report, controllers resume from it
+	// after losing their counter
+	TelemetrySequence uint64 `json:"telemetry_sequence,omitempty"`
+}
+
+type MachineStatus string
+
+const (
+	MachineAvailable   MachineStatus = "available"
+	MachineInUse       MachineStatus = "in_use"
+	MachineMaintenance MachineStatus = "maintenance"
+	MachineBroken      MachineStatus = "broken"
+)
+
+func (s MachineStatus) IsValid() bool {
+	switch s {
+	case MachineAvailable, MachineInUse, MachineMaintenance, MachineBroken:
+		return true
+	}
+	return false
+}
+
+type MachineType string
+
+const (
+	MachineWasher MachineType = "washer"
+	MachineDryer  MachineType = "dryer"
+	MachineCombo  MachineType = "combo" // Washes and dries in the same drum
+)
+
+// MachineTypesFor returns the machine types able to run the stage.
+func MachineTypesFor(stage Stage) []MachineType {
+	switch stage {
+	case StageWash:
+		return []MachineType{MachineWasher, MachineCombo}
+	case StageDry:
+		return []MachineType{MachineDryer, MachineCombo}
+	}
+	return []MachineType{MachineCombo}
 }
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// apiKeyTouchInterval limits how often using a key writes its last used time.
+const apiKeyTouchInterval = time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid, expired or revoked api key")
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db}
+}
+
+func (repo *APIKeyRepository) CreateAPIKey(key *model.APIKey, permissions []string) error {
+	perms, err := lookupPermissions(repo.db, permissions)
+	if err != nil {
+		return err
+	}
+	key.Permissions = perms
+	return repo.db.Omit("Permissions.*").Create(key).Error
+}
+
+var apiKeyListSpec = ListSpec{
+	Sorts:       map[string]string{"name": "name", "created_at": "created_at"},
+	Search:      []string{"name"},
+	DefaultSort: "-created_at",
+}
+
+func (repo *APIKeyRepository) GetAllAPIKeys(query ListQuery) (Page[model.APIKey], error) {
+	return paginate[model.APIKey](repo.db, apiKeyListSpec, query, "Permissions")
+}
+
+func (repo *APIKeyRepository) RevokeAPIKey(id string) error {
+	result := repo.db.Model(&model.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the active key with the hash and records that it was used.
+func (repo *APIKeyRepository) Authenticate(keyHash string) (model.APIKey, error) {
+	var key model.APIKey
+	result := repo.db.Preload("Permissions").Where("key_hash = ?", keyHash).Limit(1).Find(&key)
+	if result.Error != nil {
+		return key, result.Error
+	}
+	if result.RowsAffected == 0 || !key.IsActive() {
+		return key, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	err := repo.db.Model(&model.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.Id, now.Add(-apiKeyTouchInterval)).
+		Update("last_used_at", now).Error
+	return key, err
+}
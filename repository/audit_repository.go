@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"gorm.io/gorm"
+)
+
+// AuditRepository only ever appends to the audit log.
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db}
+}
+
+func (repo *AuditRepository) Record(entry *model.AuditEntry) error {
+	return repo.db.Create(entry).Error
+}
+
+// GetEntries returns the entries matching the filter, newest first.
+func (repo *AuditRepository) GetEntries(filter model.AuditFilter) ([]model.AuditEntry, error) {
+	query := repo.db.Order("created_at DESC, id DESC")
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var entries []model.AuditEntry
+	err := query.Find(&entries).Error
+	return entries, err
+}
@@ -17,10 +17,18 @@ func (repo *ClientRepository) CreateClient(client *model.Client) error {
 	return repo.db.Create(client).Error
 }
 
-func (repo *ClientRepository) GetAllClients() ([]model.Client, error) {
-	var client []model.Client
-	err := repo.db.Find(&client).Error
-	return client, err
+var clientListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"email":      {Column: "email", Kind: FilterExact},
+		"created_at": {Column: "created_at", Kind: FilterRange},
+	},
+	Sorts:       map[string]string{"name": "name", "email": "email", "created_at": "created_at"},
+	Search:      []string{"name", "email", "address"},
+	DefaultSort: "name",
+}
+
+func (repo *ClientRepository) GetAllClients(query ListQuery) (Page[model.Client], error) {
+	return paginate[model.Client](repo.db, clientListSpec, query)
 }
 
 func (repo *ClientRepository) GetClientByID(id string) (model.Client, error) {
@@ -17,6 +17,13 @@ func NewDB(cfg *config.Config) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// Without a cap a burst of requests opens as many Postgres backends as it likes
+	sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
+
 	if err := model.Migrate(db); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Expansions are the relations a resource can embed in its responses with the expand parameter, by
+// parameter name to the association preloaded. Nested relations are written with a dot on both sides.
+// Preloading runs one query per relation for all the items at once, never one per item.
+type Expansions map[string]string
+
+// preloads returns the associations to preload for the requested expansions.
+func (e Expansions) preloads(expand []string) ([]string, error) {
+	var preloads []string
+	for _, name := range expand {
+		association, ok := e[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot expand %s, expandable relations are: %s", ErrInvalidQuery, name, e.names())
+		}
+		preloads = append(preloads, association)
+	}
+	return preloads, nil
+}
+
+func (e Expansions) names() string {
+	if len(e) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// expand preloads the requested expansions on the query.
+func expand(db *gorm.DB, expansions Expansions, names []string) (*gorm.DB, error) {
+	preloads, err := expansions.preloads(names)
+	if err != nil {
+		return nil, err
+	}
+	for _, preload := range preloads {
+		db = db.Preload(preload)
+	}
+	return db, nil
+}
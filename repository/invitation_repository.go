@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var ErrInvalidInvitation = errors.New("invitation is invalid, expired or already used")
+
+type InvitationRepository struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) *InvitationRepository {
+	return &InvitationRepository{db}
+}
+
+func (repo *InvitationRepository) CreateInvitation(invitation *model.Invitation) error {
+	return repo.db.Create(invitation).Error
+}
+
+var invitationListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"email": {Column: "email", Kind: FilterExact},
+		"role":  {Column: "role", Kind: FilterExact},
+	},
+	Sorts:       map[string]string{"created_at": "created_at", "expires_at": "expires_at"},
+	DefaultSort: "-created_at",
+}
+
+func (repo *InvitationRepository) GetAllInvitations(query ListQuery) (Page[model.Invitation], error) {
+	return paginate[model.Invitation](repo.db, invitationListSpec, query)
+}
+
+// RevokeInvitation makes a pending invitation unusable.
+func (repo *InvitationRepository) RevokeInvitation(id string) error {
+	result := repo.db.Model(&model.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// GetPendingInvitation returns the invitation with the token hash, or ErrInvalidInvitation when there is
+// none or it can't be accepted anymore.
+func (repo *InvitationRepository) GetPendingInvitation(tokenHash string) (model.Invitation, error) {
+	var invitation model.Invitation
+	result := repo.db.Where("token_hash = ?", tokenHash).Limit(1).Find(&invitation)
+	if result.Error != nil {
+		return invitation, result.Error
+	}
+	if result.RowsAffected == 0 || !invitation.IsPending() {
+		return invitation, ErrInvalidInvitation
+	}
+	return invitation, nil
+}
+
+// AcceptInvitation creates the user with the role and email of the invitation the token belongs to,
+// and uses the invitation up so the token can't create a second account.
+func (repo *InvitationRepository) AcceptInvitation(tokenHash string, user *model.User) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var invitation model.Invitation
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", tokenHash).Limit(1).Find(&invitation)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || !invitation.IsPending() {
+			return ErrInvalidInvitation
+		}
+
+		user.Role = invitation.Role
+		user.Email = invitation.Email
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Model(&invitation).Updates(map[string]interface{}{
+			"accepted_at": time.Now(),
+			"accepted_by": user.Id,
+		}).Error
+	})
+}
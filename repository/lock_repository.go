@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+
+	"gorm.io/gorm"
+)
+
+type LockRepository struct {
+	db *gorm.DB
+}
+
+func NewLockRepository(db *gorm.DB) *LockRepository {
+	return &LockRepository{db}
+}
+
+// AdvisoryLock is a Postgres session level advisory lock. It lives as long as the dedicated
+// connection that acquired it, so it is released automatically if the process dies.
+type AdvisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// TryAcquire attempts to take the named advisory lock without waiting. It returns nil when
+// another session already holds it.
+func (repo *LockRepository) TryAcquire(ctx context.Context, name string) (*AdvisoryLock, error) {
+	sqlDB, err := repo.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(name)
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		// The lock may have been granted before the error, so the session must not go back to the pool
+		discard(conn)
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return &AdvisoryLock{conn: conn, key: key}, nil
+}
+
+// XactLock is a Postgres transaction level advisory lock, released as soon as its transaction ends.
+// Unlike AdvisoryLock it can't outlive a failed release on a pooled connection.
+type XactLock struct {
+	tx *sql.Tx
+}
+
+// TryAcquireXact attempts to take the named advisory lock in a transaction of its own without waiting.
+// It returns nil when another session already holds it.
+func (repo *LockRepository) TryAcquireXact(ctx context.Context, name string) (*XactLock, error) {
+	sqlDB, err := repo.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var acquired bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", lockKey(name)).Scan(&acquired); err != nil || !acquired {
+		tx.Rollback()
+		return nil, err
+	}
+	return &XactLock{tx: tx}, nil
+}
+
+func (lock *XactLock) Release() error {
+	return lock.tx.Rollback()
+}
+
+// Alive reports whether the session holding the lock is still connected.
+func (lock *AdvisoryLock) Alive(ctx context.Context) bool {
+	return lock.conn.PingContext(ctx) == nil
+}
+
+func (lock *AdvisoryLock) Release(ctx context.Context) error {
+	_, err := lock.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lock.key)
+	if err != nil {
+		discard(lock.conn)
+		return err
+	}
+	return lock.conn.Close()
+}
+
+// discard closes the connection instead of returning it to the pool, ending its session and every
+// session level lock it still holds.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(any) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
+
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
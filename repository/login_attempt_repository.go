@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db}
+}
+
+func (repo *LoginAttemptRepository) RecordAttempt(attempt *model.LoginAttempt) error {
+	return repo.db.Create(attempt).Error
+}
+
+// CountAccountFailures counts the failed logins for the username since the later of since and its
+// last successful login, and returns when the latest of them happened.
+func (repo *LoginAttemptRepository) CountAccountFailures(username string, since time.Time) (int64, time.Time, error) {
+	lastSuccess := repo.db.Model(&model.LoginAttempt{}).Select("COALESCE(MAX(created_at), ?)", since).
+		Where("username = ? AND success", username)
+	return repo.countFailures(repo.db.Where("username = ? AND created_at > ? AND created_at > (?)", username, since, lastSuccess))
+}
+
+// CountIPFailures counts the failed logins from the IP since the given time, whatever the username.
+func (repo *LoginAttemptRepository) CountIPFailures(ip string, since time.Time) (int64, time.Time, error) {
+	return repo.countFailures(repo.db.Where("ip = ? AND created_at > ?", ip, since))
+}
+
+func (repo *LoginAttemptRepository) countFailures(scope *gorm.DB) (int64, time.Time, error) {
+	var result struct {
+		Count int64
+		Last  *time.Time
+	}
+	err := repo.db.Model(&model.LoginAttempt{}).Select("COUNT(*) AS count, MAX(created_at) AS last").
+		Where("NOT success").Where(scope).Scan(&result).Error
+	if err != nil || result.Last == nil {
+		return result.Count, time.Time{}, err
+	}
+	return result.Count, *result.Last, nil
+}
+
+// GetAttempts lists login attempts, newest first, optionally only the failed ones or those for one
+// username or IP.
+func (repo *LoginAttemptRepository) GetAttempts(username string, ip string, failedOnly bool, limit int) ([]model.LoginAttempt, error) {
+	query := repo.db.Order("created_at DESC").Limit(limit)
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+	if failedOnly {
+		query = query.Where("NOT success")
+	}
+	var attempts []model.LoginAttempt
+	err := query.Find(&attempts).Error
+	return attempts, err
+}
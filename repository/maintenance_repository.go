@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+type MaintenanceRepository struct {
+	db *gorm.DB
+}
+
+func NewMaintenanceRepository(db *gorm.DB) *MaintenanceRepository {
+	return &MaintenanceRepository{db}
+}
+
+// ReportFault records the fault, marks the machine as broken so the assigner skips it and fails the cycle
+// it was running, if any, all in one transaction. When the machine already has an open fault no new one is
+// recorded; fault is filled with the open one and false is returned, so a controller that keeps reporting
+// the same error doesn't pile up faults.
+func (repo *MaintenanceRepository) ReportFault(fault *model.MachineFault) (bool, error) {
+	created := false
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = reportFault(tx, fault)
+		return err
+	})
+	return created, err
+}
+
+func reportFault(tx *gorm.DB, fault *model.MachineFault) (bool, error) {
+	// Updating the machine first locks its row, so concurrent reports look for open faults one at a time
+	err := tx.Model(&model.WashingMachine{}).Where("id = ?", fault.MachineID).Update("status", model.MachineBroken).Error
+	if err != nil {
+		return false, err
+	}
+	var open model.MachineFault
+	result := tx.Where("machine_id = ? AND resolved_at IS NULL", fault.MachineID).Order("created_at").Limit(1).Find(&open)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	created := result.RowsAffected == 0
+	if created {
+		err = tx.Create(fault).Error
+	} else {
+		*fault = open
+	}
+	if err != nil {
+		return false, err
+	}
+	err = finishCycle(tx, fault.MachineID, model.CycleFailed, "machine fault: "+fault.Description)
+	if err != nil && !errors.Is(err, ErrNoRunningCycle) {
+		return false, err
+	}
+	return created, nil
+}
+
+// ResolveFault closes the fault and puts the machine back in rotation once it has no open faults left.
+func (repo *MaintenanceRepository) ResolveFault(machineID string, faultID string, resolution string) (model.MachineFault, error) {
+	var fault model.MachineFault
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ? AND machine_id = ? AND resolved_at IS NULL", faultID, machineID).First(&fault).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		fault.ResolvedAt = &now
+		fault.Resolution = resolution
+		if err := tx.Model(&fault).Select("resolved_at", "resolution").Updates(&fault).Error; err != nil {
+			return err
+		}
+		var open int64
+		err = tx.Model(&model.MachineFault{}).Where("machine_id = ? AND resolved_at IS NULL", machineID).Count(&open).Error
+		if err != nil || open > 0 {
+			return err
+		}
+		return tx.Model(&model.WashingMachine{}).Where("id = ? AND status = ?", machineID, model.MachineBroken).
+			Updates(map[string]interface{}{"status": model.MachineAvailable, "occupied": false}).Error
+	})
+	return fault, err
+}
+
+func (repo *MaintenanceRepository) CreateWindow(window *model.MaintenanceWindow) error {
+	return repo.db.Create(window).Error
+}
+
+func (repo *MaintenanceRepository) DeleteWindow(machineID string, windowID string) error {
+	return repo.db.Where("id = ? AND machine_id = ?", windowID, machineID).Delete(&model.MaintenanceWindow{}).Error
+}
+
+// SyncMaintenanceStatus puts idle machines into maintenance while one of their windows is active and
+// back into rotation once it is over.
+func (repo *MaintenanceRepository) SyncMaintenanceStatus(now time.Time) error {
+	active := repo.db.Model(&model.MaintenanceWindow{}).Select("1").
+		Where("machine_id = washing_machines.id AND starts_at <= ? AND ends_at > ?", now, now)
+	err := repo.db.Model(&model.WashingMachine{}).
+		Where("status = ? AND EXISTS (?)", model.MachineAvailable, active).
+		Update("status", model.MachineMaintenance).Error
+	if err != nil {
+		return err
+	}
+	return repo.db.Model(&model.WashingMachine{}).
+		Where("status = ? AND NOT EXISTS (?)", model.MachineMaintenance, active).
+		Update("status", model.MachineAvailable).Error
+}
+
+// GetMaintenanceLog collects the faults, maintenance windows and cycle counts of a machine. Downtime
+// adds up the time spent with open faults and inside maintenance windows up to now.
+func (repo *MaintenanceRepository) GetMaintenanceLog(machine model.WashingMachine) (model.MaintenanceLog, error) {
+	summary := model.MaintenanceLog{MachineID: machine.Id, Status: machine.Status}
+	err := repo.db.Where("machine_id = ?", machine.Id).Order("created_at").Find(&summary.Faults).Error
+	if err != nil {
+		return summary, err
+	}
+	err = repo.db.Where("machine_id = ?", machine.Id).Order("starts_at").Find(&summary.Windows).Error
+	if err != nil {
+		return summary, err
+	}
+	err = repo.db.Model(&model.MachineAssignment{}).Where("machine_id = ?", machine.Id).Count(&summary.CycleCount).Error
+	if err != nil {
+		return summary, err
+	}
+	err = repo.db.Model(&model.MachineAssignment{}).Where("machine_id = ? AND outcome = ?", machine.Id, model.CycleFailed).
+		Count(&summary.FailedCycles).Error
+	if err != nil {
+		return summary, err
+	}
+
+	now := time.Now()
+	var downtime time.Duration
+	for _, fault := range summary.Faults {
+		end := now
+		if fault.ResolvedAt != nil {
+			end = *fault.ResolvedAt
+		}
+		downtime += end.Sub(fault.CreatedAt)
+	}
+	for _, window := range summary.Windows {
+		if window.StartsAt.After(now) {
+			continue
+		}
+		end := window.EndsAt
+		if end.After(now) {
+			end = now
+		}
+		downtime += end.Sub(window.StartsAt)
+	}
+	summary.DowntimeMinutes = downtime.Minutes()
+	return summary, nil
+}
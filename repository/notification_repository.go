@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// NotificationRepository passes wake-up signals between replicas with Postgres NOTIFY and LISTEN.
+type NotificationRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) *NotificationRepository {
+	return &NotificationRepository{db}
+}
+
+func (repo *NotificationRepository) Publish(ctx context.Context, channel string) error {
+	return repo.db.WithContext(ctx).Exec("SELECT pg_notify(?, '')", channel).Error
+}
+
+// Listen calls notified for every notification on the channel until ctx is cancelled or the dedicated
+// connection it listens on fails, which is returned.
+func (repo *NotificationRepository) Listen(ctx context.Context, channel string, notified func()) error {
+	sqlDB, err := repo.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("LISTEN needs the pgx driver")
+		}
+		pgxConn := stdlibConn.Conn()
+		if _, err := pgxConn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+		for {
+			if _, err := pgxConn.WaitForNotification(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			notified()
+		}
+	})
+}
@@ -17,10 +17,17 @@ func (repo *ProductRepository) CreateProduct(product *model.Product) error {
 	return repo.db.Create(product).Error
 }
 
-func (repo *ProductRepository) GetAllProducts() ([]model.Product, error) {
-	var products []model.Product
-	err := repo.db.Find(&products).Error
-	return products, err
+var productListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"quantity": {Column: "quantity", Kind: FilterRange},
+	},
+	Sorts:       map[string]string{"name": "name", "quantity": "quantity", "created_at": "created_at"},
+	Search:      []string{"name"},
+	DefaultSort: "name",
+}
+
+func (repo *ProductRepository) GetAllProducts(query ListQuery) (Page[model.Product], error) {
+	return paginate[model.Product](repo.db, productListSpec, query)
 }
 
 func (repo *ProductRepository) GetProductByID(id string) (model.Product, error) {
@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// ListQuery is what a client asks of a list endpoint. Filters hold the raw query string values by
+// parameter name, they are checked against the ListSpec of the entity.
+type ListQuery struct {
+	Limit   int
+	Offset  int
+	Cursor  string // Continues after the last item of the previous page, takes precedence over Offset
+	Sort    string // Comma separated fields, a leading - sorts descending
+	Search  string
+	Expand  []string
+	Filters map[string]string
+}
+
+// Page is the envelope every list endpoint answers with. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type FilterKind int
+
+const (
+	FilterExact FilterKind = iota
+	FilterBool
+	FilterRange // Filtered with <param>_from and <param>_to, inclusive and exclusive
+)
+
+type Filter struct {
+	Column string
+	Kind   FilterKind
+}
+
+// ListSpec lists what clients may filter, sort and search an entity on. Sort columns must not be
+// null, since cursors compare them.
+type ListSpec struct {
+	Filters     map[string]Filter
+	Sorts       map[string]string // Sort parameter to column
+	Search      []string          // Columns searched by the search term
+	DefaultSort string
+	Expansions  Expansions
+}
+
+type sortColumn struct {
+	column string
+	desc   bool
+}
+
+// paginate returns one page of the entities matching the query, with the total number of matches and
+// the cursor of the next page. The preloaded and expanded associations are only loaded for the items of
+// the page.
+func paginate[T any](db *gorm.DB, spec ListSpec, query ListQuery, preloads ...string) (Page[T], error) {
+	page := Page[T]{Items: []T{}, Limit: query.Limit, Offset: query.Offset}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+	if page.Limit > MaxPageSize || page.Offset < 0 {
+		return page, fmt.Errorf("%w: limit must be at most %d and offset positive", ErrInvalidQuery, MaxPageSize)
+	}
+	if query.Cursor != "" {
+		page.Offset = 0
+	}
+
+	expanded, err := spec.Expansions.preloads(query.Expand)
+	if err != nil {
+		return page, err
+	}
+	preloads = append(preloads, expanded...)
+	entity, err := schema.Parse(new(T), schemaCache, db.NamingStrategy)
+	if err != nil {
+		return page, err
+	}
+	filtered, err := applyFilters(db.Model(new(T)), spec, query)
+	if err != nil {
+		return page, err
+	}
+	if err := filtered.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return page, err
+	}
+
+	sorts, err := parseSort(spec, query.Sort)
+	if err != nil {
+		return page, err
+	}
+	sorts = append(sorts, sortColumn{column: entity.PrioritizedPrimaryField.DBName, desc: sorts[len(sorts)-1].desc})
+	find := filtered.Session(&gorm.Session{})
+	for _, preload := range preloads {
+		find = find.Preload(preload)
+	}
+	for _, sort := range sorts {
+		find = find.Order(clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: sort.column}, Desc: sort.desc})
+	}
+	if query.Cursor != "" {
+		if find, err = afterCursor(find, sorts, query.Cursor); err != nil {
+			return page, err
+		}
+	} else {
+		find = find.Offset(page.Offset)
+	}
+	if err := find.Limit(page.Limit).Find(&page.Items).Error; err != nil {
+		return page, err
+	}
+
+	if len(page.Items) == page.Limit {
+		last := reflect.ValueOf(page.Items).Index(len(page.Items) - 1)
+		page.NextCursor, err = encodeCursor(db, entity, sorts, last)
+	}
+	return page, err
+}
+
+func applyFilters(db *gorm.DB, spec ListSpec, query ListQuery) (*gorm.DB, error) {
+	for param, value := range query.Filters {
+		filter, ok := spec.Filters[param]
+		bound := ""
+		if !ok {
+			for _, suffix := range []string{"_from", "_to"} {
+				if name, cut := strings.CutSuffix(param, suffix); cut {
+					filter, ok = spec.Filters[name]
+					bound = suffix
+				}
+			}
+			if !ok || filter.Kind != FilterRange {
+				return nil, fmt.Errorf("%w: unknown filter %s", ErrInvalidQuery, param)
+			}
+		} else if filter.Kind == FilterRange {
+			return nil, fmt.Errorf("%w: filter %s with %s_from and %s_to", ErrInvalidQuery, param, param, param)
+		}
+
+		column := clause.Column{Table: clause.CurrentTable, Name: filter.Column}
+		switch filter.Kind {
+		case FilterExact:
+			db = db.Where(clause.Eq{Column: column, Value: value})
+		case FilterBool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s must be true or false", ErrInvalidQuery, param)
+			}
+			db = db.Where(clause.Eq{Column: column, Value: parsed})
+		case FilterRange:
+			parsed, err := parseRangeValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidQuery, param, err)
+			}
+			if bound == "_from" {
+				db = db.Where(clause.Gte{Column: column, Value: parsed})
+			} else {
+				db = db.Where(clause.Lt{Column: column, Value: parsed})
+			}
+		}
+	}
+
+	if query.Search != "" && len(spec.Search) == 0 {
+		return nil, fmt.Errorf("%w: search is not supported here", ErrInvalidQuery)
+	}
+	if query.Search != "" {
+		columns := make([]string, len(spec.Search))
+		for i, column := range spec.Search {
+			columns[i] = "COALESCE(" + db.Statement.Quote(column) + ", '')"
+		}
+		text := "concat_ws(' ', " + strings.Join(columns, ", ") + ")"
+		db = db.Where("(to_tsvector('simple', "+text+") @@ plainto_tsquery('simple', ?) OR "+text+" ILIKE ?)",
+			query.Search, "%"+escapeLike(query.Search)+"%")
+	}
+	return db, nil
+}
+
+// parseRangeValue accepts RFC 3339 times, plain dates and numbers.
+func parseRangeValue(value string) (interface{}, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := time.Parse(time.DateOnly, value); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+		return parsed, nil
+	}
+	return nil, errors.New("expected a date, an RFC 3339 time or a number")
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func parseSort(spec ListSpec, sort string) ([]sortColumn, error) {
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	var sorts []sortColumn
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		column, ok := spec.Sorts[strings.TrimPrefix(field, "-")]
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot sort by %s", ErrInvalidQuery, field)
+		}
+		sorts = append(sorts, sortColumn{column: column, desc: desc})
+	}
+	return sorts, nil
+}
+
+// afterCursor keeps the rows that sort after the cursor, comparing column by column so mixed sort
+// directions work: (a > x) OR (a = x AND b < y) OR ...
+func afterCursor(db *gorm.DB, sorts []sortColumn, cursor string) (*gorm.DB, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	var values []interface{}
+	if err := decoder.Decode(&values); err != nil || len(values) != len(sorts) {
+		return nil, fmt.Errorf("%w: cursor doesn't match the sort order", ErrInvalidQuery)
+	}
+	for i, value := range values {
+		if number, ok := value.(json.Number); ok {
+			if integer, err := number.Int64(); err == nil {
+				values[i] = integer
+			} else {
+				values[i], _ = number.Float64()
+			}
+		}
+	}
+
+	var alternatives []clause.Expression
+	for i, sort := range sorts {
+		var conditions []clause.Expression
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: sorts[j].column}, Value: values[j]})
+		}
+		column := clause.Column{Table: clause.CurrentTable, Name: sort.column}
+		if sort.desc {
+			conditions = append(conditions, clause.Lt{Column: column, Value: values[i]})
+		} else {
+			conditions = append(conditions, clause.Gt{Column: column, Value: values[i]})
+		}
+		alternatives = append(alternatives, clause.And(conditions...))
+	}
+	return db.Where(clause.Or(alternatives...)), nil
+}
+
+func encodeCursor(db *gorm.DB, entity *schema.Schema, sorts []sortColumn, item reflect.Value) (string, error) {
+	values := make([]interface{}, len(sorts))
+	for i, sort := range sorts {
+		field := entity.LookUpField(sort.column)
+		if field == nil {
+			return "", fmt.Errorf("unknown sort column %s", sort.column)
+		}
+		values[i], _ = field.ValueOf(db.Statement.Context, item)
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+var schemaCache = &sync.Map{}
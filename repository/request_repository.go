@@ -2,7 +2,10 @@ package repository
 
 import (
 	"LavanderiaBackend/model"
+	"fmt"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RequestRepository struct {
@@ -13,26 +16,139 @@ func NewRequestRepository(db *gorm.DB) *RequestRepository {
 	return &RequestRepository{db}
 }
 
-func (repo *RequestRepository) CreateRequest(request *model.Request) error {
-	return repo.db.Create(request).Error
+func (repo *RequestRepository) CreateRequest(request *model.Request, changedBy *uuid.UUID) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		request.ApplyStatus(model.StatusReceived)
+		if err := tx.Omit("Services.*").Create(request).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.RequestStatusHistory{
+			RequestID: request.Id,
+			ToStatus:  model.StatusReceived,
+			ChangedBy: changedBy,
+		}).Error
+	})
 }
 
-func (repo *RequestRepository) GetAllRequests() ([]model.Request, error) {
-	var requests []model.Request
-	err := repo.db.Find(&requests).Error
-	return requests, err
+var requestListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"status":             {Column: "status", Kind: FilterExact},
+		"client_id":          {Column: "client_id", Kind: FilterExact},
+		"washing_machine_id": {Column: "washing_machine_id", Kind: FilterExact},
+		"fulfilled":          {Column: "fulfilled", Kind: FilterBool},
+		"ongoing":            {Column: "ongoing", Kind: FilterBool},
+		"ordered_date":       {Column: "ordered_date", Kind: FilterRange},
+		"created_at":         {Column: "created_at", Kind: FilterRange},
+		"grand_total":        {Column: "grand_total", Kind: FilterRange},
+	},
+	Sorts: map[string]string{
+		"created_at":   "created_at",
+		"ordered_date": "ordered_date",
+		"grand_total":  "grand_total",
+		"status":       "status",
+	},
+	DefaultSort: "-created_at",
+	Expansions:  requestExpansions,
 }
 
-func (repo *RequestRepository) GetRequestByID(id string) (model.Request, error) {
+// requestExpansions are the relations the request endpoints accept in the expand parameter.
+var requestExpansions = Expansions{
+	"services":          "Services",
+	"services.products": "Services.Products",
+	"client":            "Client",
+	"washing_machine":   "WashingMachine",
+	"line_items":        "LineItems",
+	"status_history":    "StatusHistory",
+}
+
+func (repo *RequestRepository) GetAllRequests(query ListQuery) (Page[model.Request], error) {
+	return paginate[model.Request](repo.db, requestListSpec, query)
+}
+
+func (repo *RequestRepository) GetRequestByID(id string, expansions ...string) (model.Request, error) {
 	var request model.Request
-	err := repo.db.Where("id = ?", id).First(&request).Error
+	db, err := expand(repo.db, requestExpansions, expansions)
+	if err != nil {
+		return request, err
+	}
+	err = db.Where("id = ?", id).First(&request).Error
 	return request, err
 }
 
-func (repo *RequestRepository) UpdateRequest(request *model.Request) error {
-	return repo.db.Save(request).Error
+// requestLifecycleColumns are owned by the transition endpoint and the assigner, updates never write them.
+var requestLifecycleColumns = []string{"status", "fulfilled", "ongoing", "fulfilled_date", "washing_machine_id"}
+
+// UpdateRequest saves the request and replaces its line items and linked services with the ones it
+// carries. The stored request is locked and checked first: once it went into a machine its lines, services
+// and load stay as they are, and changing the lines fails with model.ErrLineItemsLocked. The status and
+// machine are taken from the stored request, so an update never undoes what happened since it was read.
+func (repo *RequestRepository) UpdateRequest(request *model.Request, linesChanged bool) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var stored model.Request
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", request.Id).First(&stored).Error; err != nil {
+			return err
+		}
+		request.ApplyStatus(stored.Status)
+		request.FulfilledDate = stored.FulfilledDate
+		request.WashingMachineID = stored.WashingMachineID
+
+		omit := append([]string{"Client", "WashingMachine", "Services.*"}, requestLifecycleColumns...)
+		if !stored.LineItemsEditable() {
+			if linesChanged {
+				return model.ErrLineItemsLocked
+			}
+			request.LoadWeight = stored.LoadWeight
+			return tx.Omit(append(omit, "LineItems", "Services", "load_weight")...).Save(request).Error
+		}
+		if err := tx.Where("request_id = ?", request.Id).Delete(&model.RequestLineItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit(omit...).Save(request).Error; err != nil {
+			return err
+		}
+		return tx.Model(request).Omit("Services.*").Association("Services").Replace(request.Services)
+	})
 }
 
 func (repo *RequestRepository) DeleteRequestByID(id string) error {
 	return repo.db.Where("id = ?", id).Delete(&model.Request{}).Error
 }
+
+// TransitionRequest moves a request to the given status and records the change in its history.
+// The request row is locked for the duration of the transaction so concurrent transitions are serialized.
+func (repo *RequestRepository) TransitionRequest(id string, to model.RequestStatus, changedBy *uuid.UUID, note string) (model.Request, error) {
+	var request model.Request
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&request).Error; err != nil {
+			return err
+		}
+		return transitionRequest(tx, &request, to, changedBy, note)
+	})
+	return request, err
+}
+
+func (repo *RequestRepository) GetStatusHistory(id string) ([]model.RequestStatusHistory, error) {
+	var history []model.RequestStatusHistory
+	err := repo.db.Where("request_id = ?", id).Order("created_at").Find(&history).Error
+	return history, err
+}
+
+// transitionRequest applies a status change inside an existing transaction.
+func transitionRequest(tx *gorm.DB, request *model.Request, to model.RequestStatus, changedBy *uuid.UUID, note string) error {
+	from := request.Status
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", model.ErrInvalidStatusTransition, from, to)
+	}
+	request.ApplyStatus(to)
+	err := tx.Model(request).Select("status", "fulfilled", "ongoing", "fulfilled_date").Updates(request).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&model.RequestStatusHistory{
+		RequestID:  request.Id,
+		FromStatus: from,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		Note:       note,
+	}).Error
+}
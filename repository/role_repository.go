@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrOwnerLockout      = errors.New("the owner role must keep the roles:manage permission")
+)
+
+// permissionCacheTTL bounds how long a permission change made through another replica can take to apply.
+const permissionCacheTTL = 30 * time.Second
+
+type cachedPermissions struct {
+	permissions map[string]bool
+	expiresAt   time.Time
+}
+
+type RoleRepository struct {
+	db    *gorm.DB
+	mu    sync.Mutex
+	cache map[string]cachedPermissions
+}
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{db: db, cache: map[string]cachedPermissions{}}
+}
+
+// SeedRoles creates every permission and any built-in role that doesn't exist yet with its default
+// permissions. Roles that already exist keep the permissions owners gave them, and only get the
+// permissions that are new since the last start when their defaults include them. Users from before
+// roles existed get the role matching their old privilege level.
+func (repo *RoleRepository) SeedRoles() error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		permissions := make(map[string]*model.Permission, len(model.AllPermissions))
+		created := map[string]bool{}
+		for _, name := range model.AllPermissions {
+			permission := &model.Permission{Name: name}
+			result := tx.FirstOrCreate(permission)
+			if result.Error != nil {
+				return result.Error
+			}
+			permissions[name] = permission
+			created[name] = result.RowsAffected > 0
+		}
+
+		for name, names := range model.DefaultRolePermissions {
+			role := model.Role{Name: name}
+			result := tx.Limit(1).Find(&role, "name = ?", name)
+			if result.Error != nil {
+				return result.Error
+			}
+			var grant []*model.Permission
+			for _, permission := range names {
+				if result.RowsAffected == 0 || created[permission] {
+					grant = append(grant, permissions[permission])
+				}
+			}
+			if result.RowsAffected == 0 {
+				role.Permissions = grant
+				if err := tx.Create(&role).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if len(grant) > 0 {
+				if err := tx.Model(&role).Omit("Permissions.*").Association("Permissions").Append(grant); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !tx.Migrator().HasColumn(&model.User{}, "privileges") {
+			return nil
+		}
+		for privileges, role := range model.LegacyPrivilegeRoles {
+			err := tx.Model(&model.User{}).Where("(role IS NULL OR role = '') AND privileges = ?", privileges).
+				Update("role", role).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&model.User{}).Where("role IS NULL OR role = ''").Update("role", model.RoleCustomer).Error
+	})
+}
+
+func (repo *RoleRepository) GetAllRoles() ([]model.Role, error) {
+	var roles []model.Role
+	err := repo.db.Preload("Permissions").Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (repo *RoleRepository) GetRoleByName(name string) (model.Role, error) {
+	var role model.Role
+	err := repo.db.Preload("Permissions").Where("name = ?", name).First(&role).Error
+	return role, err
+}
+
+func (repo *RoleRepository) CreateRole(role *model.Role, permissions []string) error {
+	perms, err := lookupPermissions(repo.db, permissions)
+	if err != nil {
+		return err
+	}
+	role.Permissions = perms
+	return repo.db.Omit("Permissions.*").Create(role).Error
+}
+
+// UpdateRole changes the description and two-factor requirement of the role.
+func (repo *RoleRepository) UpdateRole(role *model.Role) error {
+	result := repo.db.Model(role).Select("description", "require_two_factor").Updates(role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetRolePermissions replaces the permissions of the role. Owners can't take roles:manage away from
+// themselves, otherwise nobody could ever fix the matrix again.
+func (repo *RoleRepository) SetRolePermissions(name string, permissions []string) (model.Role, error) {
+	if name == model.RoleOwner && !containsString(permissions, model.PermRolesManage) {
+		return model.Role{}, ErrOwnerLockout
+	}
+	role, err := repo.GetRoleByName(name)
+	if err != nil {
+		return role, err
+	}
+	perms, err := lookupPermissions(repo.db, permissions)
+	if err != nil {
+		return role, err
+	}
+	err = repo.db.Model(&role).Omit("Permissions.*").Association("Permissions").Replace(perms)
+	if err != nil {
+		return role, err
+	}
+	repo.mu.Lock()
+	delete(repo.cache, name)
+	repo.mu.Unlock()
+	role.Permissions = perms
+	return role, nil
+}
+
+// HasPermission reports whether the role grants the permission, using a short lived cache since it
+// is checked on every request.
+func (repo *RoleRepository) HasPermission(roleName string, permission string) (bool, error) {
+	repo.mu.Lock()
+	entry, ok := repo.cache[roleName]
+	repo.mu.Unlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		role, err := repo.GetRoleByName(roleName)
+		if err != nil {
+			return false, err
+		}
+		entry = cachedPermissions{permissions: map[string]bool{}, expiresAt: time.Now().Add(permissionCacheTTL)}
+		for _, name := range role.PermissionNames() {
+			entry.permissions[name] = true
+		}
+		repo.mu.Lock()
+		repo.cache[roleName] = entry
+		repo.mu.Unlock()
+	}
+	return entry.permissions[permission], nil
+}
+
+// CanGrant reports whether every permission of role is also held by granter, so nobody can hand out
+// more access than they have.
+func (repo *RoleRepository) CanGrant(granter string, role string) (bool, error) {
+	target, err := repo.GetRoleByName(role)
+	if err != nil {
+		return false, err
+	}
+	return repo.HasAllPermissions(granter, target.PermissionNames())
+}
+
+// HasAllPermissions reports whether the role grants every one of the permissions.
+func (repo *RoleRepository) HasAllPermissions(roleName string, permissions []string) (bool, error) {
+	for _, permission := range permissions {
+		allowed, err := repo.HasPermission(roleName, permission)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func lookupPermissions(db *gorm.DB, names []string) ([]*model.Permission, error) {
+	var permissions []*model.Permission
+	if err := db.Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	if len(permissions) != len(names) {
+		known := make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			known[permission.Name] = true
+		}
+		for _, name := range names {
+			if !known[name] {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, name)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -17,18 +17,46 @@ func (repo *ServiceRepository) CreateService(service *model.Service) error {
 	return repo.db.Create(service).Error
 }
 
-func (repo *ServiceRepository) GetAllServices() ([]model.Service, error) {
-	var services []model.Service
-	err := repo.db.Find(&services).Error
-	return services, err
+var serviceListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"is_washing":    {Column: "is_washing", Kind: FilterBool},
+		"is_drying":     {Column: "is_drying", Kind: FilterBool},
+		"is_full_cycle": {Column: "is_full_cycle", Kind: FilterBool},
+		"priced_per_kg": {Column: "priced_per_kg", Kind: FilterBool},
+		"inactive":      {Column: "inactive", Kind: FilterBool},
+		"price":         {Column: "price", Kind: FilterRange},
+	},
+	Sorts:       map[string]string{"name": "name", "price": "price", "created_at": "created_at"},
+	Search:      []string{"name"},
+	DefaultSort: "name",
+	Expansions:  serviceExpansions,
+}
+
+// serviceExpansions are the relations the service endpoints accept in the expand parameter.
+var serviceExpansions = Expansions{
+	"products": "Products",
+}
+
+func (repo *ServiceRepository) GetAllServices(query ListQuery) (Page[model.Service], error) {
+	return paginate[model.Service](repo.db, serviceListSpec, query)
 }
 
-func (repo *ServiceRepository) GetServiceByID(id string) (model.Service, error) {
+func (repo *ServiceRepository) GetServiceByID(id string, expansions ...string) (model.Service, error) {
 	var service model.Service
-	err := repo.db.Where("id = ?", id).First(&service).Error
+	db, err := expand(repo.db, serviceExpansions, expansions)
+	if err != nil {
+		return service, err
+	}
+	err = db.Where("id = ?", id).First(&service).Error
 	return service, err
 }
 
+func (repo *ServiceRepository) GetServicesByIDs(ids []int) ([]model.Service, error) {
+	var services []model.Service
+	err := repo.db.Where("id IN ?", ids).Find(&services).Error
+	return services, err
+}
+
 func (repo *ServiceRepository) UpdateService(service *model.Service) error {
 	return repo.db.Save(service).Error
 }
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, session revoked")
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db}
+}
+
+func (repo *SessionRepository) CreateSession(session *model.Session) error {
+	return repo.db.Create(session).Error
+}
+
+func (repo *SessionRepository) GetSessionByID(id uuid.UUID) (model.Session, error) {
+	var session model.Session
+	err := repo.db.Where("id = ?", id).First(&session).Error
+	return session, err
+}
+
+// RotateRefreshToken swaps the refresh token of the session holding tokenHash for newHash. Presenting a
+// token that was already rotated away means it leaked, so the whole session is revoked.
+func (repo *SessionRepository) RotateRefreshToken(tokenHash string, newHash string, expiresAt time.Time) (model.Session, error) {
+	var session model.Session
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("refresh_token_hash = ?", tokenHash).Limit(1).Find(&session)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			result = tx.Where("previous_refresh_hash = ? AND revoked_at IS NULL", tokenHash).Limit(1).Find(&session)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInvalidRefreshToken
+			}
+			return ErrRefreshTokenReused
+		}
+		if !session.IsActive() {
+			return ErrInvalidRefreshToken
+		}
+		session.PreviousRefreshHash = session.RefreshTokenHash
+		session.RefreshTokenHash = newHash
+		session.ExpiresAt = expiresAt
+		return tx.Model(&session).Select("previous_refresh_hash", "refresh_token_hash", "expires_at").Updates(&session).Error
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if revokeErr := repo.RevokeSession(session.Id); revokeErr != nil {
+			return session, revokeErr
+		}
+	}
+	return session, err
+}
+
+func (repo *SessionRepository) RevokeSession(id uuid.UUID) error {
+	return repo.db.Model(&model.Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+}
+
+func (repo *SessionRepository) RevokeUserSessions(userID string) (int64, error) {
+	result := repo.db.Model(&model.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func (repo *SessionRepository) GetUserSessions(userID string) ([]model.Session, error) {
+	var sessions []model.Session
+	err := repo.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&sessions).Error
+	return sessions, err
+}
@@ -2,15 +2,28 @@ package repository
 
 import (
 	"LavanderiaBackend/model"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"sync"
+	"time"
 )
 
+// userCacheTTL bounds how long a change made through another replica can take to be seen.
+const userCacheTTL = 30 * time.Second
+
+type cachedUser struct {
+	user      model.User
+	expiresAt time.Time
+}
+
 type UserRepository struct {
-	db *gorm.DB
+	db    *gorm.DB
+	mu    sync.Mutex
+	cache map[uuid.UUID]cachedUser
 }
 
 func NewUserRepository(db *gorm.DB) *UserRepository {
-	return &UserRepository{db}
+	return &UserRepository{db: db, cache: map[uuid.UUID]cachedUser{}}
 }
 
 func (repo *UserRepository) CreateUser(user *model.User) error {
@@ -18,10 +31,17 @@ func (repo *UserRepository) CreateUser(user *model.User) error {
 	return repo.db.Create(user).Error
 }
 
-func (repo *UserRepository) GetAllUsers() ([]model.User, error) {
-	var users []model.User
-	err := repo.db.Find(&users).Error
-	return users, err
+var userListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"role": {Column: "role", Kind: FilterExact},
+	},
+	Sorts:       map[string]string{"username": "username", "email": "email", "created_at": "created_at"},
+	Search:      []string{"username", "email"},
+	DefaultSort: "username",
+}
+
+func (repo *UserRepository) GetAllUsers(query ListQuery) (Page[model.User], error) {
+	return paginate[model.User](repo.db, userListSpec, query)
 }
 
 func (repo *UserRepository) GetUserByID(id string) (model.User, error) {
@@ -30,6 +50,30 @@ func (repo *UserRepository) GetUserByID(id string) (model.User, error) {
 	return user, err
 }
 
+// GetCachedUserByID is GetUserByID behind a short lived cache, for lookups done on every request.
+func (repo *UserRepository) GetCachedUserByID(id uuid.UUID) (model.User, error) {
+	repo.mu.Lock()
+	entry, ok := repo.cache[id]
+	repo.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.user, nil
+	}
+	user, err := repo.GetUserByID(id.String())
+	if err != nil {
+		return user, err
+	}
+	repo.mu.Lock()
+	repo.cache[id] = cachedUser{user: user, expiresAt: time.Now().Add(userCacheTTL)}
+	repo.mu.Unlock()
+	return user, nil
+}
+
+func (repo *UserRepository) forget(id uuid.UUID) {
+	repo.mu.Lock()
+	delete(repo.cache, id)
+	repo.mu.Unlock()
+}
+
 func (repo *UserRepository) GetUserByUsername(username string) (model.User, error) {
 	var user model.User
 	result := repo.db.Where("username = ?", username).First(&user)
@@ -40,9 +84,108 @@ func (repo *UserRepository) GetUserByUsername(username string) (model.User, erro
 }
 
 func (repo *UserRepository) UpdateUser(user *model.User) error {
+	defer repo.forget(user.Id)
 	return repo.db.Save(user).Error
 }
 
 func (repo *UserRepository) DeleteUser(id string) error {
+	if parsed, err := uuid.Parse(id); err == nil {
+		defer repo.forget(parsed)
+	}
 	return repo.db.Where("id = ?", id).Delete(&model.User{}).Error
 }
+
+func (repo *UserRepository) CountUsersWithRole(role string) (int64, error) {
+	var count int64
+	err := repo.db.Model(&model.User{}).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+// ReassignRole moves every user with role from to role to, except the given user, and returns how many
+// users changed.
+func (repo *UserRepository) ReassignRole(from, to string, except uuid.UUID) (int64, error) {
+	result := repo.db.Model(&model.User{}).Where("role = ? AND id <> ?", from, except).Update("role", to)
+	repo.mu.Lock()
+	repo.cache = map[uuid.UUID]cachedUser{}
+	repo.mu.Unlock()
+	return result.RowsAffected, result.Error
+}
+
+func (repo *UserRepository) GetUserByEmail(email string) (model.User, error) {
+	var user model.User
+	err := repo.db.Where("email = ?", email).First(&user).Error
+	return user, err
+}
+
+// SetTOTPSecret stores the secret of a two-factor enrollment that still has to be confirmed with a code.
+func (repo *UserRepository) SetTOTPSecret(userID uuid.UUID, secret string) error {
+	defer repo.forget(userID)
+	return repo.db.Model(&model.User{}).Where("id = ? AND totp_enabled_at IS NULL", userID).
+		Update("totp_secret", secret).Error
+}
+
+// EnableTOTP turns on two-factor authentication once the user entered a valid code, replacing any
+// recovery codes they had.
+func (repo *UserRepository) EnableTOTP(userID uuid.UUID, step int64, recoveryHashes []string) error {
+	defer repo.forget(userID)
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled_at": time.Now(), "totp_last_step": step}).Error
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, userID, recoveryHashes)
+	})
+}
+
+func (repo *UserRepository) DisableTOTP(userID uuid.UUID) error {
+	defer repo.forget(userID)
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": "", "totp_enabled_at": nil, "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+// AcceptTOTPStep records that a code of the time step was used, and reports false when that step or a
+// later one was already used.
+func (repo *UserRepository) AcceptTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	defer repo.forget(userID)
+	result := repo.db.Model(&model.User{}).Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (repo *UserRepository) ReplaceRecoveryCodes(userID uuid.UUID, hashes []string) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+}
+
+// UseRecoveryCode spends the recovery code, reporting false when it doesn't exist or was already used.
+func (repo *UserRepository) UseRecoveryCode(userID uuid.UUID, hash string) (bool, error) {
+	result := repo.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (repo *UserRepository) CountRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := repo.db.Model(&model.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID, hashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]model.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = model.RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+	return tx.Create(&codes).Error
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var ErrInvalidUserToken = errors.New("token is invalid, expired or already used")
+
+type UserTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) *UserTokenRepository {
+	return &UserTokenRepository{db}
+}
+
+// CreateToken stores a new token and voids the unused ones the user had for the same purpose, so only
+// the most recent email works.
+func (repo *UserTokenRepository) CreateToken(token *model.UserToken) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// GetLiveToken returns the unused and unexpired token with the hash, without using it up.
+func (repo *UserTokenRepository) GetLiveToken(tokenHash string, purpose model.TokenPurpose) (model.UserToken, error) {
+	var token model.UserToken
+	result := repo.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, time.Now()).
+		Limit(1).Find(&token)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return token, ErrInvalidUserToken
+	}
+	return token, result.Error
+}
+
+// ResetPassword sets the password of the user the reset token belongs to and signs them out
+// everywhere, since whoever knew the old password may still hold a session.
+func (repo *UserTokenRepository) ResetPassword(tokenHash string, passwordHash string) (model.UserToken, error) {
+	var token model.UserToken
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		token, err = consumeToken(tx, tokenHash, model.TokenPasswordReset)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&model.User{}).Where("id = ?", token.UserID).Update("password", passwordHash).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Session{}).Where("user_id = ? AND revoked_at IS NULL", token.UserID).
+			Update("revoked_at", time.Now()).Error
+	})
+	return token, err
+}
+
+// VerifyEmail marks the email of the user as verified, as long as it is still the address the token
+// was sent to.
+func (repo *UserTokenRepository) VerifyEmail(tokenHash string) (model.UserToken, error) {
+	var token model.UserToken
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		token, err = consumeToken(tx, tokenHash, model.TokenEmailVerification)
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&model.User{}).Where("id = ? AND email = ?", token.UserID, token.Email).
+			Update("email_verified_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidUserToken
+		}
+		return nil
+	})
+	return token, err
+}
+
+func consumeToken(tx *gorm.DB, tokenHash string, purpose model.TokenPurpose) (model.UserToken, error) {
+	var token model.UserToken
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ?", tokenHash, purpose).Limit(1).Find(&token)
+	if result.Error != nil {
+		return token, result.Error
+	}
+	if result.RowsAffected == 0 || token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return token, ErrInvalidUserToken
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	return token, tx.Model(&token).Update("used_at", now).Error
+}
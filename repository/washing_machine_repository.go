@@ -2,8 +2,17 @@ package repository
 
 import (
 	"LavanderiaBackend/model"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrRequestUnavailable = errors.New("request is no longer waiting or is being assigned elsewhere")
+	ErrNoRunningCycle     = errors.New("machine is not running a cycle")
 )
 
 type WashingMachineRepository struct {
@@ -18,10 +27,20 @@ func (repo *WashingMachineRepository) CreateWashingMachine(WashingMachine *model
 	return repo.db.Create(WashingMachine).Error
 }
 
-func (repo *WashingMachineRepository) GetAllWashingMachines() ([]model.WashingMachine, error) {
-	var washingMachines []model.WashingMachine
-	err := repo.db.Find(&washingMachines).Error
-	return washingMachines, err
+var washingMachineListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"status":       {Column: "status", Kind: FilterExact},
+		"machine_type": {Column: "machine_type", Kind: FilterExact},
+		"occupied":     {Column: "occupied", Kind: FilterBool},
+		"capacity":     {Column: "capacity", Kind: FilterRange},
+	},
+	Sorts:       map[string]string{"machine_model": "machine_model", "capacity": "capacity", "status": "status", "created_at": "created_at"},
+	Search:      []string{"machine_model"},
+	DefaultSort: "created_at",
+}
+
+func (repo *WashingMachineRepository) GetAllWashingMachines(query ListQuery) (Page[model.WashingMachine], error) {
+	return paginate[model.WashingMachine](repo.db, washingMachineListSpec, query)
 }
 
 func (repo *WashingMachineRepository) GetWashingMachineByID(id string) (model.WashingMachine, error) {
@@ -38,35 +57,248 @@ func (repo *WashingMachineRepository) DeleteWashingMachine(id string) error {
 	return repo.db.Delete(&model.WashingMachine{}, "id = ?", id).Error
 }
 
-func (repo *WashingMachineRepository) FetchWashingRequests() ([]model.Request, error) {
+// ApplyTelemetry records the report as the last one of the machine and applies it, all in one
+// transaction so a report that fails to apply can be sent again. It returns false, changing nothing,
+// when a report with the same or a later sequence number was already accepted.
+func (repo *WashingMachineRepository) ApplyTelemetry(machineId uuid.UUID, event model.TelemetryEvent) (bool, error) {
+	accepted := false
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.WashingMachine{}).Where("id = ? AND telemetry_sequence < ?", machineId, event.Sequence).
+			Updates(map[string]interface{}{"telemetry_sequence": event.Sequence, "last_telemetry_at": time.Now()})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		accepted = true
+		return applyTelemetry(tx, machineId, event)
+	})
+	return accepted, err
+}
+
+func applyTelemetry(tx *gorm.DB, machineId uuid.UUID, event model.TelemetryEvent) error {
+	machine := tx.Model(&model.WashingMachine{}).Where("id = ?", machineId)
+	switch event.Type {
+	case model.TelemetryDoorOpened, model.TelemetryDoorClosed:
+		return machine.Update("door_open", event.Type == model.TelemetryDoorOpened).Error
+	case model.TelemetryCycleStarted, model.TelemetryCycleProgress:
+		err := machine.Updates(map[string]interface{}{"door_open": false, "remaining_seconds": event.RemainingSeconds}).Error
+		if err != nil {
+			return err
+		}
+		expectedEnd := time.Now().Add(time.Duration(event.RemainingSeconds) * time.Second)
+		return tx.Model(&model.MachineAssignment{}).Where("machine_id = ? AND finished_at IS NULL", machineId).
+			Update("expected_end_at", expectedEnd).Error
+	case model.TelemetryCycleFinished:
+		if err := machine.Update("remaining_seconds", 0).Error; err != nil {
+			return err
+		}
+		err := finishCycle(tx, machineId, model.CycleCompleted, "reported by the machine")
+		if errors.Is(err, ErrNoRunningCycle) {
+			return nil
+		}
+		return err
+	case model.TelemetryError:
+		if err := machine.Update("error_code", event.ErrorCode).Error; err != nil {
+			return err
+		}
+		_, err := reportFault(tx, &model.MachineFault{
+			MachineID:   machineId,
+			Description: "machine reported error code " + event.ErrorCode,
+		})
+		return err
+	}
+	return fmt.Errorf("unknown telemetry event type %s", event.Type)
+}
+
+// FetchWaitingRequests returns the requests waiting for a machine: queued ones and the ones that
+// finished washing and are waiting for a dryer.
+func (repo *WashingMachineRepository) FetchWaitingRequests() ([]model.Request, error) {
 	var requests []model.Request
-	err := repo.db.Preload("Services").Where("fulfilled = false").Find(&requests).Error
+	err := repo.db.Preload("Services").
+		Where("status = ?", model.StatusQueued).
+		Or("status = ? AND NOT EXISTS (?)", model.StatusDrying,
+			repo.db.Model(&model.MachineAssignment{}).Select("1").Where("request_id = requests.id AND finished_at IS NULL")).
+		Order("created_at").Find(&requests).Error
 	if err != nil {
 		return nil, err
 	}
 
-	var washingRequests []model.Request
+	var waiting []model.Request
 	for _, req := range requests {
-		for _, service := range req.Services {
-			if service.Name == "washing" {
-				washingRequests = append(washingRequests, req)
-				break
+		if req.RequiresWashing() {
+			waiting = append(waiting, req)
+		}
+	}
+	return waiting, nil
+}
+
+func (repo *WashingMachineRepository) GetAssignmentsByRequest(requestID string) ([]model.MachineAssignment, error) {
+	var assignments []model.MachineAssignment
+	err := repo.db.Where("request_id = ?", requestID).Order("created_at").Find(&assignments).Error
+	return assignments, err
+}
+
+// AssignMachines claims available machines able to run the stage, and not due for maintenance before
+// until, for a waiting request and moves the request into that stage, all in one transaction. The
+// request and the machines are selected with FOR UPDATE SKIP LOCKED, so concurrent assigners never wait
+// on or double book the same rows. plan receives the claimed machines, smallest capacity first, and
+// returns the assignments to make; an empty plan leaves everything untouched.
+func (repo *WashingMachineRepository) AssignMachines(requestID uuid.UUID, stage model.Stage, until time.Time, plan func([]model.WashingMachine) []model.MachineAssignment) ([]model.MachineAssignment, error) {
+	var assignments []model.MachineAssignment
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		skipLocked := clause.Locking{Strength: "UPDATE", Options: clause.LockingOptionsSkipLocked}
+
+		var request model.Request
+		result := tx.Clauses(skipLocked).
+			Where("id = ? AND status IN ?", requestID, []model.RequestStatus{model.StatusQueued, model.StatusDrying}).
+			Where("NOT EXISTS (?)", tx.Model(&model.MachineAssignment{}).Select("1").Where("request_id = requests.id AND finished_at IS NULL")).
+			Limit(1).Find(&request)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRequestUnavailable
+		}
+
+		var machines []model.WashingMachine
+		err := tx.Clauses(skipLocked).
+			Where("status = ? AND machine_type IN ?", model.MachineAvailable, model.MachineTypesFor(stage)).
+			Where("NOT EXISTS (?)", tx.Model(&model.MaintenanceWindow{}).Select("1").
+				Where("machine_id = washing_machines.id AND starts_at < ? AND ends_at > ?", until, time.Now())).
+			Order("capacity").Find(&machines).Error
+		if err != nil {
+			return err
+		}
+		assignments = plan(machines)
+		if len(assignments) == 0 {
+			return nil
+		}
+
+		for i := range assignments {
+			err := tx.Model(&model.WashingMachine{}).Where("id = ?", assignments[i].MachineID).
+				Updates(map[string]interface{}{"status": model.MachineInUse, "occupied": true}).Error
+			if err != nil {
+				return err
+			}
+			assignments[i].RequestID = requestID
+			if err := tx.Create(&assignments[i]).Error; err != nil {
+				return err
 			}
 		}
+		err = tx.Model(&request).Update("washing_machine_id", assignments[0].MachineID).Error
+		if err != nil {
+			return err
+		}
+		next := model.StatusWashing
+		if stage == model.StageDry {
+			next = model.StatusDrying
+		}
+		if request.Status == next {
+			return nil
+		}
+		return transitionRequest(tx, &request, next, nil, assignments[0].Reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// FinishCycle frees the machine, closes its running assignment with the given outcome and unlinks the
+// request. Once the last machine working on a request finishes, the request moves on to the next stage.
+// A failed cycle stops every machine working on the request and puts it back in the queue.
+func (repo *WashingMachineRepository) FinishCycle(machineId uuid.UUID, outcome model.CycleOutcome, note string) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		return finishCycle(tx, machineId, outcome, note)
+	})
+}
+
+func finishCycle(tx *gorm.DB, machineId uuid.UUID, outcome model.CycleOutcome, note string) error {
+	var assignment model.MachineAssignment
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("machine_id = ? AND finished_at IS NULL", machineId).Limit(1).Find(&assignment)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoRunningCycle
+	}
+
+	var request model.Request
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Services").Where("id = ?", assignment.RequestID).First(&request).Error
+	if err != nil {
+		return err
 	}
-	return washingRequests, nil
+	if err := finishAssignment(tx, &assignment, outcome, note); err != nil {
+		return err
+	}
+
+	if outcome == model.CycleFailed {
+		var running []model.MachineAssignment
+		err := tx.Where("request_id = ? AND finished_at IS NULL", request.Id).Find(&running).Error
+		if err != nil {
+			return err
+		}
+		for i := range running {
+			if err := finishAssignment(tx, &running[i], model.CycleFailed, "stopped because another part of the load failed"); err != nil {
+				return err
+			}
+		}
+	}
+
+	var running model.MachineAssignment
+	result = tx.Where("request_id = ? AND finished_at IS NULL", request.Id).Limit(1).Find(&running)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return tx.Model(&request).Update("washing_machine_id", running.MachineID).Error
+	}
+	if err := tx.Model(&request).Update("washing_machine_id", nil).Error; err != nil {
+		return err
+	}
+	switch {
+	case request.Status == model.StatusWashing && outcome == model.CycleFailed:
+		return transitionRequest(tx, &request, model.StatusQueued, nil, "washing failed: "+note)
+	case request.Status == model.StatusWashing && assignment.Stage == model.StageWash && request.NeedsDrying():
+		return transitionRequest(tx, &request, model.StatusDrying, nil, "washing finished, waiting for a dryer")
+	case request.Status == model.StatusWashing:
+		return transitionRequest(tx, &request, model.StatusFolding, nil, "washing finished")
+	case request.Status == model.StatusDrying && outcome != model.CycleFailed:
+		return transitionRequest(tx, &request, model.StatusFolding, nil, "drying finished")
+	}
+	// A failed drying cycle leaves the request waiting for another dryer
+	return nil
 }
 
-func (repo *WashingMachineRepository) AssignMachineToRequest(machine model.WashingMachine, requestID uuid.UUID) error {
-	return repo.db.Model(&machine).Update("current_request_id", requestID).Error
+// GetDueAssignments returns the running assignments whose expected end is not after the given time.
+func (repo *WashingMachineRepository) GetDueAssignments(now time.Time) ([]model.MachineAssignment, error) {
+	var assignments []model.MachineAssignment
+	err := repo.db.Where("finished_at IS NULL AND expected_end_at <= ?", now).Order("expected_end_at").Find(&assignments).Error
+	return assignments, err
 }
 
-func (repo *WashingMachineRepository) GetAvailableMachine() (*model.WashingMachine, error) {
-	var machine model.WashingMachine
-	result := repo.db.Where("occupied = false").First(&machine)
-	return &machine, result.Error
+// GetNextExpectedEnd returns when the next running cycle should finish, or nil if nothing is running.
+func (repo *WashingMachineRepository) GetNextExpectedEnd() (*time.Time, error) {
+	var assignment model.MachineAssignment
+	result := repo.db.Where("finished_at IS NULL").Order("expected_end_at").Limit(1).Find(&assignment)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &assignment.ExpectedEndAt, nil
 }
 
-func (repo *WashingMachineRepository) SetMachineAvailable(machineId uuid.UUID) error {
-	return repo.db.Model(&model.WashingMachine{}).Where("id = ?", machineId).Update("occupied", false).Error
+func finishAssignment(tx *gorm.DB, assignment *model.MachineAssignment, outcome model.CycleOutcome, note string) error {
+	now := time.Now()
+	assignment.FinishedAt = &now
+	assignment.Outcome = outcome
+	assignment.Note = note
+	err := tx.Model(assignment).Select("finished_at", "outcome", "note").Updates(assignment).Error
+	if err != nil {
+		return err
+	}
+	// A machine reported broken while running stays broken
+	return tx.Model(&model.WashingMachine{}).Where("id = ?", assignment.MachineID).Updates(map[string]interface{}{
+		"occupied": false,
+		"status":   gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END", model.MachineInUse, model.MachineAvailable),
+	}).Error
 }
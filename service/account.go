@@ -0,0 +1,97 @@
+package services
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+var ErrNoEmail = errors.New("user has no email address")
+
+// AccountService mails the single use tokens that let users reset their password and verify their
+// email.
+type AccountService struct {
+	Users     *repository.UserRepository
+	Tokens    *repository.UserTokenRepository
+	Mailer    Mailer
+	PublicURL string
+}
+
+func NewAccountService(users *repository.UserRepository, tokens *repository.UserTokenRepository, mailer Mailer, publicURL string) *AccountService {
+	return &AccountService{Users: users, Tokens: tokens, Mailer: mailer, PublicURL: strings.TrimRight(publicURL, "/")}
+}
+
+// RequestPasswordReset mails a reset token when the email belongs to a user. Unknown emails are not an
+// error, so the endpoint can't be used to find out who has an account.
+func (as *AccountService) RequestPasswordReset(email string) error {
+	user, err := as.Users.GetUserByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := as.issueToken(user, model.TokenPasswordReset, auth.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Someone asked to reset the password of %s.\n\n"+
+		"Use this token within %s to choose a new one:\n\n%s\n\n%s/password/reset?token=%s\n\n"+
+		"If it wasn't you, ignore this email.",
+		user.Username, auth.PasswordResetTTL, token, as.PublicURL, token)
+	return as.Mailer.Send(user.Email, "Reset your password", body)
+}
+
+// ResetPassword sets the new password when the reset token is still good. The token is looked up before
+// hashing the password, so made up tokens don't cost a bcrypt each.
+func (as *AccountService) ResetPassword(token string, password string) error {
+	tokenHash := auth.HashOneTimeToken(token)
+	if _, err := as.Tokens.GetLiveToken(tokenHash, model.TokenPasswordReset); err != nil {
+		return err
+	}
+	passwordHash, err := auth.HashingPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = as.Tokens.ResetPassword(tokenHash, passwordHash)
+	return err
+}
+
+// SendVerification mails the user a token proving they own their email address.
+func (as *AccountService) SendVerification(user model.User) error {
+	if user.Email == "" {
+		return ErrNoEmail
+	}
+	token, err := as.issueToken(user, model.TokenEmailVerification, auth.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Confirm that %s is your email address with this token:\n\n%s\n\n%s/email/verify?token=%s",
+		user.Email, token, as.PublicURL, token)
+	return as.Mailer.Send(user.Email, "Verify your email", body)
+}
+
+func (as *AccountService) VerifyEmail(token string) error {
+	_, err := as.Tokens.VerifyEmail(auth.HashOneTimeToken(token))
+	return err
+}
+
+func (as *AccountService) issueToken(user model.User, purpose model.TokenPurpose, ttl time.Duration) (string, error) {
+	token, tokenHash, err := auth.NewOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+	err = as.Tokens.CreateToken(&model.UserToken{
+		UserID:    user.Id,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return token, err
+}
@@ -1,54 +1,332 @@
 package services
 
 import (
+	"LavanderiaBackend/model"
 	"LavanderiaBackend/repository"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"log"
+	"sort"
 	"time"
 )
 
+// assignerLockName identifies the advisory lock that elects the single active assigner across replicas.
+const assignerLockName = "lavanderia.assignment"
+
+// assignerChannel is the Postgres notification channel Notify wakes the assigners of every replica on.
+const assignerChannel = "lavanderia_assignment"
+
 type AssignmentService struct {
-	Repo *repository.WashingMachineRepository
+	Repo          *repository.WashingMachineRepository
+	Maintenance   *repository.MaintenanceRepository
+	Locks         *repository.LockRepository
+	Notifications *repository.NotificationRepository
+	SweepInterval time.Duration
+	leader        *repository.AdvisoryLock
+	trigger       chan struct{}
+	ctx           context.Context
+}
+
+func NewAssignmentService(repo *repository.WashingMachineRepository, maintenance *repository.MaintenanceRepository, locks *repository.LockRepository, notifications *repository.NotificationRepository, sweepInterval time.Duration) *AssignmentService {
+	return &AssignmentService{
+		Repo:          repo,
+		Maintenance:   maintenance,
+		Locks:         locks,
+		Notifications: notifications,
+		SweepInterval: sweepInterval,
+		trigger:       make(chan struct{}, 1),
+		ctx:           context.Background(),
+	}
+}
+
+// Notify asks the assigner to run a pass as soon as possible, on whichever replica is the active one. It
+// never blocks; notifications that arrive while a pass is already pending are merged into it.
+func (as *AssignmentService) Notify() {
+	as.wake()
+	go func() {
+		if err := as.Notifications.Publish(as.ctx, assignerChannel); err != nil {
+			log.Printf("Error notifying the other assigners: %v", err)
+		}
+	}()
+}
+
+// wake asks the assigner of this process to run a pass.
+func (as *AssignmentService) wake() {
+	select {
+	case as.trigger <- struct{}{}:
+	default:
+	}
 }
 
-func NewAssignmentService(repo *repository.WashingMachineRepository) *AssignmentService {
-	return &AssignmentService{Repo: repo}
+// listen wakes this process whenever another replica calls Notify, reconnecting until ctx is cancelled.
+func (as *AssignmentService) listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := as.Notifications.Listen(ctx, assignerChannel, as.wake); err != nil {
+			log.Printf("Error listening for assignment notifications: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			// A pass covers whatever was notified while not listening
+			as.wake()
+		}
+	}
 }
 
-func (as *AssignmentService) StartAssignmentProcess() {
+// StartAssignmentProcess runs assignment passes whenever Notify is called, when a running cycle is
+// due to end, and every SweepInterval as a fallback, until ctx is cancelled.
+func (as *AssignmentService) StartAssignmentProcess(ctx context.Context) {
+	as.ctx = ctx
+	ticker := time.NewTicker(as.SweepInterval)
+	defer ticker.Stop()
+	cycleTimer := time.NewTimer(as.SweepInterval)
+	defer cycleTimer.Stop()
+	defer as.releaseLeadership()
+	go as.listen(ctx)
+
+	as.wake()
 	for {
-		time.Sleep(6 * time.Minute) // Check every 10 minutes
-		requests, err := as.Repo.FetchWashingRequests()
-		if err != nil {
-			log.Printf("Error fetching requests: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-as.trigger:
+		case <-ticker.C:
+		case <-cycleTimer.C:
+		}
+		if !as.ensureLeadership() {
 			continue
 		}
-		for _, req := range requests {
-			if !req.RequiresWashing() {
-				continue
+		as.completeDueCycles()
+		if err := as.Maintenance.SyncMaintenanceStatus(time.Now()); err != nil {
+			log.Printf("Error syncing maintenance windows: %v", err)
+		}
+		as.assignPendingRequests()
+		as.scheduleNextCycleEnd(cycleTimer)
+	}
+}
+
+// FinishCycle ends the cycle running on the machine ahead of time, e.g. when an operator sees it
+// finished early or failed, and lets the assigner reuse the machine.
+func (as *AssignmentService) FinishCycle(machineId uuid.UUID, outcome model.CycleOutcome, note string) error {
+	if err := as.Repo.FinishCycle(machineId, outcome, note); err != nil {
+		return err
+	}
+	log.Printf("Cycle on machine %s ended: %s", machineId, outcome)
+	as.Notify()
+	return nil
+}
+
+// ReportFault takes the machine out of rotation and fails the cycle it was running, if any, so the
+// load is queued again. It returns false when the machine already had an open fault, which fault is
+// then filled with.
+func (as *AssignmentService) ReportFault(fault *model.MachineFault) (bool, error) {
+	created, err := as.Maintenance.ReportFault(fault)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		log.Printf("Machine %s reported broken: %s", fault.MachineID, fault.Description)
+	}
+	as.Notify()
+	return created, nil
+}
+
+// completeDueCycles frees every machine whose cycle should have ended by now. Because cycles are
+// tracked in the database this also reconciles cycles left running by a previous process.
+func (as *AssignmentService) completeDueCycles() {
+	due, err := as.Repo.GetDueAssignments(time.Now())
+	if err != nil {
+		log.Printf("Error fetching finished cycles: %v", err)
+		return
+	}
+	for _, assignment := range due {
+		err := as.Repo.FinishCycle(assignment.MachineID, model.CycleCompleted, "")
+		if err != nil && !errors.Is(err, repository.ErrNoRunningCycle) {
+			log.Printf("Error completing cycle on machine %s: %v", assignment.MachineID, err)
+			continue
+		}
+		log.Printf("Machine %s is now available", assignment.MachineID)
+	}
+}
+
+func (as *AssignmentService) scheduleNextCycleEnd(timer *time.Timer) {
+	next, err := as.Repo.GetNextExpectedEnd()
+	if err != nil {
+		log.Printf("Error fetching next cycle end: %v", err)
+		return
+	}
+	wait := as.SweepInterval
+	if next != nil && time.Until(*next) < wait {
+		wait = time.Until(*next)
+	}
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(wait)
+}
+
+func (as *AssignmentService) releaseLeadership() {
+	if as.leader == nil {
+		return
+	}
+	if err := as.leader.Release(context.Background()); err != nil {
+		log.Printf("Error releasing assigner lock: %v", err)
+	}
+	as.leader = nil
+}
+
+// ensureLeadership reports whether this process is the active assigner, trying to become it when no
+// other replica holds the lock.
+func (as *AssignmentService) ensureLeadership() bool {
+	if as.leader != nil {
+		if as.leader.Alive(as.ctx) {
+			return true
+		}
+		log.Printf("Lost assigner leadership, connection holding the lock is gone")
+		as.releaseLeadership()
+	}
+	lock, err := as.Locks.TryAcquire(as.ctx, assignerLockName)
+	if err != nil {
+		log.Printf("Error acquiring assigner lock: %v", err)
+		return false
+	}
+	if lock == nil {
+		return false
+	}
+	log.Printf("Became the active assigner")
+	as.leader = lock
+	return true
+}
+
+func (as *AssignmentService) assignPendingRequests() {
+	requests, err := as.Repo.FetchWaitingRequests()
+	if err != nil {
+		log.Printf("Error fetching requests: %v", err)
+		return
+	}
+	for _, req := range requests {
+		stage := nextStage(req)
+		// Skip machines with maintenance before even the longest program this request could run would end
+		until := time.Now().Add(req.StageDuration(model.StageFullCycle))
+		var reason string
+		assignments, err := as.Repo.AssignMachines(req.Id, stage, until, func(machines []model.WashingMachine) []model.MachineAssignment {
+			plan := planLoad(machines, req.LoadWeight)
+			reason = plan.Reason
+			loadStage := stage
+			if stage == model.StageWash && req.NeedsDrying() && allCombo(plan.Loads) {
+				loadStage = model.StageFullCycle
+				reason += ", washing and drying in the same machine"
 			}
-			machine, err := as.Repo.GetAvailableMachine()
-			if err != nil {
-				log.Printf("No available machines: %v", err)
-				continue
+			duration := req.StageDuration(loadStage)
+			if duration <= 0 {
+				duration = model.DefaultWashingDuration
 			}
-			err = as.Repo.AssignMachineToRequest(*machine, req.Id)
-			if err != nil {
-				log.Printf("Failed to assign machine: %v", err)
-				continue
+			assignments := make([]model.MachineAssignment, 0, len(plan.Loads))
+			for _, load := range plan.Loads {
+				assignments = append(assignments, model.MachineAssignment{
+					MachineID:     load.Machine.Id,
+					Stage:         loadStage,
+					LoadWeight:    load.Weight,
+					Reason:        reason,
+					ExpectedEndAt: time.Now().Add(duration),
+				})
 			}
-			log.Printf("Assigned machine %s to request %s", machine.Id, req.Id)
-			go as.handleServiceCompletion(machine.Id)
+			return assignments
+		})
+		if errors.Is(err, repository.ErrRequestUnavailable) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to assign machines to request %s: %v", req.Id, err)
+			continue
+		}
+		if len(assignments) == 0 {
+			log.Printf("Request %s not assigned for %s: %s", req.Id, stage, reason)
+			continue
+		}
+		for _, assignment := range assignments {
+			log.Printf("Assigned machine %s to request %s for %s until %s: %s", assignment.MachineID, req.Id, assignment.Stage, assignment.ExpectedEndAt.Format(time.Kitchen), reason)
 		}
 	}
 }
 
-func (as *AssignmentService) handleServiceCompletion(machineId uuid.UUID) {
-	time.Sleep(5 * time.Minute) // Simulate service time
-	err := as.Repo.SetMachineAvailable(machineId)
-	if err != nil {
-		log.Printf("Error setting machine to available: %v", err)
-		return
+// nextStage returns the stage a waiting request has to go through next: washing first when any of its
+// services washes, drying once washing is done or when it only dries.
+func nextStage(req model.Request) model.Stage {
+	if req.Status == model.StatusQueued && req.NeedsWashing() {
+		return model.StageWash
+	}
+	return model.StageDry
+}
+
+func allCombo(loads []machineLoad) bool {
+	for _, load := range loads {
+		if load.Machine.MachineType != model.MachineCombo {
+			return false
+		}
+	}
+	return len(loads) > 0
+}
+
+type machineLoad struct {
+	Machine model.WashingMachine
+	Weight  float64
+}
+
+type loadPlan struct {
+	Loads  []machineLoad
+	Reason string
+}
+
+// planLoad picks the smallest free machine that fits the whole load. When no single machine is big
+// enough the load is split across the largest free machines. An empty plan means the load has to wait.
+func planLoad(machines []model.WashingMachine, weight float64) loadPlan {
+	if len(machines) == 0 {
+		return loadPlan{Reason: "no machines available"}
+	}
+	sorted := make([]model.WashingMachine, len(machines))
+	copy(sorted, machines)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Capacity < sorted[j].Capacity })
+
+	if weight <= 0 {
+		return loadPlan{
+			Loads:  []machineLoad{{Machine: sorted[0], Weight: 0}},
+			Reason: fmt.Sprintf("no load weight recorded, using smallest available machine (%.1f kg)", sorted[0].Capacity),
+		}
+	}
+
+	for _, machine := range sorted {
+		if machine.Capacity >= weight {
+			return loadPlan{
+				Loads:  []machineLoad{{Machine: machine, Weight: weight}},
+				Reason: fmt.Sprintf("smallest available machine that fits %.1f kg (capacity %.1f kg)", weight, machine.Capacity),
+			}
+		}
+	}
+
+	var loads []machineLoad
+	remaining := weight
+	for i := len(sorted) - 1; i >= 0 && remaining > 0; i-- {
+		if sorted[i].Capacity <= 0 {
+			break
+		}
+		part := sorted[i].Capacity
+		if part > remaining {
+			part = remaining
+		}
+		loads = append(loads, machineLoad{Machine: sorted[i], Weight: part})
+		remaining -= part
+	}
+	if remaining > 0 {
+		return loadPlan{Reason: fmt.Sprintf("not enough free capacity for %.1f kg", weight)}
+	}
+	return loadPlan{
+		Loads:  loads,
+		Reason: fmt.Sprintf("%.1f kg exceeds every free machine, split across %d machines", weight, len(loads)),
 	}
-	log.Printf("Machine %s is now available", machineId)
 }
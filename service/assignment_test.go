@@ -0,0 +1,151 @@
+package services
+
+import (
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNEnv names the variable holding the DSN of a Postgres database the tests may use. Every test
+// works in a schema of its own that is dropped afterwards.
+const testDSNEnv = "LAVANDERIA_TEST_DSN"
+
+// openTestDB connects to the test database inside a fresh schema, migrated and ready to use, and skips
+// the test when no test database is configured.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatalf("creating uuid-ossp: %v", err)
+	}
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	// The search path goes in the DSN so every pooled connection gets it, public keeps uuid_generate_v4
+	searchPath := schema + ",public"
+	if strings.Contains(dsn, "://") {
+		parsed, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", testDSNEnv, err)
+		}
+		values := parsed.Query()
+		values.Set("search_path", searchPath)
+		parsed.RawQuery = values.Encode()
+		dsn = parsed.String()
+	} else {
+		dsn += " search_path=" + searchPath
+	}
+	db, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatalf("connecting to the test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := model.Migrate(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return db
+}
+
+// TestConcurrentAssignersNeverDoubleBook runs assignment passes of several assigners at once against the
+// same database, as replicas do while leadership changes hands, and checks no machine ends up with two
+// running assignments.
+func TestConcurrentAssignersNeverDoubleBook(t *testing.T) {
+	db := openTestDB(t)
+
+	const machines, requests, assigners = 4, 12, 6
+	for i := 0; i < machines; i++ {
+		machine := model.WashingMachine{MachineModel: fmt.Sprintf("Washer %d", i), MachineType: model.MachineWasher, Capacity: 8, Status: model.MachineAvailable}
+		if err := db.Create(&machine).Error; err != nil {
+			t.Fatalf("creating machine: %v", err)
+		}
+	}
+	service := model.Service{Name: "Wash", Price: 5, IsWashing: true}
+	if err := db.Create(&service).Error; err != nil {
+		t.Fatalf("creating service: %v", err)
+	}
+	client := model.Client{Name: "Client"}
+	if err := db.Create(&client).Error; err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+	for i := 0; i < requests; i++ {
+		request := model.Request{OrderedDate: time.Now(), ClientID: client.Id, LoadWeight: 5, Services: []*model.Service{&service}}
+		request.ApplyStatus(model.StatusQueued)
+		if err := db.Omit("Services.*").Create(&request).Error; err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+	}
+
+	repo := repository.NewWashingMachineRepository(db)
+	maintenance := repository.NewMaintenanceRepository(db)
+	locks := repository.NewLockRepository(db)
+	notifications := repository.NewNotificationRepository(db)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < assigners; i++ {
+		as := NewAssignmentService(repo, maintenance, locks, notifications, time.Minute)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for pass := 0; pass < 3; pass++ {
+				as.assignPendingRequests()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	var doubleBooked []string
+	err := db.Model(&model.MachineAssignment{}).Where("finished_at IS NULL").
+		Group("machine_id").Having("count(*) > 1").Pluck("machine_id", &doubleBooked).Error
+	if err != nil {
+		t.Fatalf("looking for double bookings: %v", err)
+	}
+	if len(doubleBooked) > 0 {
+		t.Errorf("machines with more than one running assignment: %v", doubleBooked)
+	}
+
+	var running, busy, started int64
+	db.Model(&model.MachineAssignment{}).Where("finished_at IS NULL").Distinct("machine_id").Count(&running)
+	db.Model(&model.WashingMachine{}).Where("status = ?", model.MachineInUse).Count(&busy)
+	db.Model(&model.Request{}).Where("status = ?", model.StatusWashing).Count(&started)
+	if running != busy {
+		t.Errorf("%d machines have a running assignment but %d are in use", running, busy)
+	}
+	if running == 0 || running > machines {
+		t.Errorf("%d machines have a running assignment, want between 1 and %d", running, machines)
+	}
+	if started > running {
+		t.Errorf("%d requests are washing on only %d machines", started, running)
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnencodable = errors.New("cannot be encoded as Code 128")
+
+// code128Patterns are the bar and space widths, in modules, of every Code 128 symbol value.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeB  = 100
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// code128Values returns the symbol values encoding data, start and check symbols included. Digits are
+// packed two per symbol with code set C, anything else uses code set B.
+func code128Values(data string) ([]int, error) {
+	if data == "" {
+		return nil, fmt.Errorf("%w: empty data", ErrUnencodable)
+	}
+	var values []int
+	if isDigits(data) {
+		values = append(values, code128StartC)
+		for len(data) >= 2 {
+			values = append(values, int(data[0]-'0')*10+int(data[1]-'0'))
+			data = data[2:]
+		}
+		if data != "" {
+			values = append(values, code128CodeB, int(data[0])-32)
+		}
+	} else {
+		values = append(values, code128StartB)
+		for _, r := range data {
+			if r < 32 || r > 126 {
+				return nil, fmt.Errorf("%w: %q", ErrUnencodable, r)
+			}
+			values = append(values, int(r)-32)
+		}
+	}
+
+	check := values[0]
+	for i, value := range values[1:] {
+		check += (i + 1) * value
+	}
+	return append(values, check%103), nil
+}
+
+// Code128 returns the widths of the alternating bars and spaces encoding data, starting with a bar, in
+// modules. The quiet zones around the code are left to the caller.
+func Code128(data string) ([]int, error) {
+	values, err := code128Values(data)
+	if err != nil {
+		return nil, err
+	}
+	var widths []int
+	for _, value := range append(values, code128Stop) {
+		for _, width := range code128Patterns[value] {
+			widths = append(widths, int(width-'0'))
+		}
+	}
+	return widths, nil
+}
+
+func isDigits(data string) bool {
+	for _, r := range data {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCode128Values(t *testing.T) {
+	tests := []struct {
+		data string
+		want []int
+	}{
+		{"PJJ123C", []int{code128StartB, 48, 42, 42, 17, 18, 19, 35, 55}},
+		{"1234", []int{code128StartC, 12, 34, 82}},
+		{"12345", []int{code128StartC, 12, 34, code128CodeB, 21, 54}}, // The odd digit goes in code set B
+		{"a{b", []int{code128StartB, 65, 91, 66, 34}},
+	}
+	for _, test := range tests {
+		got, err := code128Values(test.data)
+		if err != nil {
+			t.Errorf("code128Values(%q) failed: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("code128Values(%q) = %v, want %v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestCode128Unencodable(t *testing.T) {
+	for _, data := range []string{"", "café", "tab\there"} {
+		if _, err := Code128(data); !errors.Is(err, ErrUnencodable) {
+			t.Errorf("Code128(%q) error = %v, want ErrUnencodable", data, err)
+		}
+	}
+}
+
+func TestCode128Widths(t *testing.T) {
+	for _, data := range []string{"1234", "12345", "PJJ123C"} {
+		values, _ := code128Values(data)
+		widths, err := Code128(data)
+		if err != nil {
+			t.Fatalf("Code128(%q) failed: %v", data, err)
+		}
+		// Every symbol is 6 elements over 11 modules, the stop symbol 7 elements over 13
+		if len(widths) != 6*len(values)+7 {
+			t.Errorf("Code128(%q) has %d bars and spaces, want %d", data, len(widths), 6*len(values)+7)
+		}
+		modules := 0
+		for _, width := range widths {
+			modules += width
+		}
+		if modules != 11*len(values)+13 {
+			t.Errorf("Code128(%q) is %d modules wide, want %d", data, modules, 11*len(values)+13)
+		}
+		if start := widths[:6]; !reflect.DeepEqual(start, patternWidths(code128Patterns[values[0]])) {
+			t.Errorf("Code128(%q) starts with %v, want the start symbol", data, start)
+		}
+	}
+}
+
+func patternWidths(pattern string) []int {
+	widths := make([]int, len(pattern))
+	for i := range pattern {
+		widths[i] = int(pattern[i] - '0')
+	}
+	return widths
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// escposWriter builds the byte stream of a receipt for ESC/POS thermal printers. Barcodes and QR codes
+// are drawn by the printer itself.
+type escposWriter struct {
+	buf bytes.Buffer
+}
+
+func newESCPOSWriter() *escposWriter {
+	w := &escposWriter{}
+	w.buf.Write([]byte{0x1b, '@'})    // Initialize
+	w.buf.Write([]byte{0x1b, 't', 0}) // Code page 437
+	return w
+}
+
+func (w *escposWriter) center(on bool) {
+	align := byte(0)
+	if on {
+		align = 1
+	}
+	w.buf.Write([]byte{0x1b, 'a', align})
+}
+
+func (w *escposWriter) bold(on bool) {
+	w.buf.Write([]byte{0x1b, 'E', boolByte(on)})
+}
+
+func (w *escposWriter) line(text string) {
+	// Control characters would reach the printer as commands, like cutting the paper or kicking the cash
+	// drawer, and the text comes from orders anyone can place
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '?'
+		}
+		return r
+	}, text)
+	encoded, err := charmap.CodePage437.NewEncoder().String(text)
+	if err != nil {
+		encoded = asciiOnly(text)
+	}
+	w.buf.WriteString(encoded)
+	w.buf.WriteByte('\n')
+}
+
+// code128 prints data as a Code 128 barcode with the text under it, using the same code sets as Code128.
+func (w *escposWriter) code128(data string) error {
+	values, err := code128Values(data)
+	if err != nil {
+		return err
+	}
+	var symbols []byte
+	if values[0] == code128StartC {
+		symbols = append(symbols, '{', 'C')
+		setB := false
+		for _, value := range values[1 : len(values)-1] {
+			switch {
+			case setB:
+				symbols = append(symbols, byte(value+32))
+			case value == code128CodeB:
+				symbols = append(symbols, '{', 'B')
+				setB = true
+			default:
+				symbols = append(symbols, byte(value))
+			}
+		}
+	} else {
+		symbols = append(append(symbols, '{', 'B'), strings.ReplaceAll(data, "{", "{{")...)
+	}
+	w.buf.Write([]byte{0x1d, 'h', 80}) // Height in dots
+	w.buf.Write([]byte{0x1d, 'w', 2})  // Module width in dots
+	w.buf.Write([]byte{0x1d, 'H', 2})  // Text below the bars
+	w.buf.Write([]byte{0x1d, 'k', 73, byte(len(symbols))})
+	w.buf.Write(symbols)
+	w.buf.WriteByte('\n')
+	return nil
+}
+
+// qr prints data as a QR code with medium error correction.
+func (w *escposWriter) qr(data string) {
+	w.buf.Write([]byte{0x1d, '(', 'k', 4, 0, '1', 'A', '2', 0}) // Model 2
+	w.buf.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'C', 6})      // Module size in dots
+	w.buf.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'E', '1'})    // Error correction M
+	length := len(data) + 3
+	w.buf.Write([]byte{0x1d, '(', 'k', byte(length), byte(length >> 8), '1', 'P', '0'})
+	w.buf.WriteString(data)
+	w.buf.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'Q', '0'}) // Print the stored code
+	w.buf.WriteByte('\n')
+}
+
+// cut feeds the paper past the cutter and cuts it, leaving a tab.
+func (w *escposWriter) cut() {
+	w.buf.Write([]byte{0x1d, 'V', 66, 0})
+}
+
+func (w *escposWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
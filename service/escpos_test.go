@@ -0,0 +1,17 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestESCPOSLineFiltersControlCharacters(t *testing.T) {
+	w := newESCPOSWriter()
+	start := w.buf.Len()
+	w.line("Ana\x1bp\x00\x19 Peña\x1dV\x00\x7f")
+	got := w.buf.Bytes()[start:]
+	want := []byte("Ana?p?? Pe\xa4a?V??\n") // ñ is 0xA4 in code page 437
+	if !bytes.Equal(got, want) {
+		t.Errorf("line wrote %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"context"
+	"strings"
+	"time"
+)
+
+const (
+	// loginWindow is how far back failed logins count against an account or IP.
+	loginWindow = 15 * time.Minute
+	// loginFreeAttempts failed logins per account are allowed back to back, every one after that has to
+	// wait twice as long as the previous one, up to loginMaxDelay.
+	loginFreeAttempts = 3
+	loginMaxDelay     = time.Minute
+	// After accountLockoutAttempts failures the account is locked for accountLockout.
+	accountLockoutAttempts = 10
+	accountLockout         = 15 * time.Minute
+	// After ipLockoutAttempts failures, for any usernames, the IP is locked out for the login window.
+	ipLockoutAttempts = 50
+)
+
+// LoginGuard throttles logins per account and per IP based on the recorded failed attempts, so it
+// works the same across replicas.
+type LoginGuard struct {
+	Attempts *repository.LoginAttemptRepository
+	Locks    *repository.LockRepository
+}
+
+func NewLoginGuard(attempts *repository.LoginAttemptRepository, locks *repository.LockRepository) *LoginGuard {
+	return &LoginGuard{Attempts: attempts, Locks: locks}
+}
+
+// TryLock serializes the login attempts for the username across replicas, returning nil when another
+// attempt holds the lock. Holding it from RetryAfter until the attempt is recorded makes every guess see
+// the failures of the ones before it, so parallel guesses can't all slip through before the first one is
+// counted. It never waits, so a flood of guesses can't pile up connections behind it.
+func (lg *LoginGuard) TryLock(ctx context.Context, username string) (*repository.XactLock, error) {
+	return lg.Locks.TryAcquireXact(ctx, "login:"+username)
+}
+
+// RetryAfter returns how long the username or IP has to wait before trying again, zero when a login
+// attempt may go ahead.
+func (lg *LoginGuard) RetryAfter(username string, ip string) (time.Duration, error) {
+	now := time.Now()
+	wait, err := lg.RetryAfterIP(ip)
+	if err != nil {
+		return 0, err
+	}
+
+	failures, last, err := lg.Attempts.CountAccountFailures(username, now.Add(-accountLockout))
+	if err != nil {
+		return 0, err
+	}
+	if accountWait := last.Add(accountDelay(failures)).Sub(now); accountWait > wait {
+		wait = accountWait
+	}
+	return wait, nil
+}
+
+// RetryAfterIP returns how long the IP has to wait before trying again, for attempts that don't name an
+// account, like using a password reset token.
+func (lg *LoginGuard) RetryAfterIP(ip string) (time.Duration, error) {
+	now := time.Now()
+	failures, last, err := lg.Attempts.CountIPFailures(ip, now.Add(-loginWindow))
+	if err != nil || failures < ipLockoutAttempts {
+		return 0, err
+	}
+	return last.Add(loginWindow).Sub(now), nil
+}
+
+// PasswordResetKey is the username password reset requests for the email are throttled and recorded
+// under. It is kept apart from the account, so asking for resets never locks anyone out of logging in.
+func PasswordResetKey(email string) string {
+	return "password-reset:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func accountDelay(failures int64) time.Duration {
+	if failures >= accountLockoutAttempts {
+		return accountLockout
+	}
+	if failures < loginFreeAttempts {
+		return 0
+	}
+	delay := time.Second << (failures - loginFreeAttempts)
+	if delay > loginMaxDelay {
+		return loginMaxDelay
+	}
+	return delay
+}
+
+func (lg *LoginGuard) Record(attempt model.LoginAttempt) error {
+	return lg.Attempts.RecordAttempt(&attempt)
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	pointsPerMM  = 72 / 25.4
+	pdfFont      = "F1"
+	pdfFontBold  = "F2"
+	pdfLineSpace = 1.25
+)
+
+// pdfDocument writes small PDFs made of text in the standard Courier fonts and filled rectangles, which
+// is all the tickets need.
+type pdfDocument struct {
+	pages []*pdfPage
+}
+
+type pdfPage struct {
+	width, height float64 // In points
+	content       bytes.Buffer
+}
+
+func (doc *pdfDocument) addPage(width, height float64) *pdfPage {
+	page := &pdfPage{width: width, height: height}
+	doc.pages = append(doc.pages, page)
+	return page
+}
+
+// text writes a line with its baseline at y, measured like every position from the bottom left corner.
+func (page *pdfPage) text(x, y, size float64, bold bool, line string) {
+	font := pdfFont
+	if bold {
+		font = pdfFontBold
+	}
+	fmt.Fprintf(&page.content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(line))
+}
+
+func (page *pdfPage) rect(x, y, width, height float64) {
+	fmt.Fprintf(&page.content, "%.3f %.3f %.3f %.3f re f\n", x, y, width, height)
+}
+
+// barcode draws the bars of a Code 128 code between x and x+width, from y up to y+height.
+func (page *pdfPage) barcode(x, y, width, height float64, widths []int) {
+	modules := 0
+	for _, w := range widths {
+		modules += w
+	}
+	module := width / float64(modules)
+	for i, w := range widths {
+		if i%2 == 0 {
+			page.rect(x, y, float64(w)*module, height)
+		}
+		x += float64(w) * module
+	}
+}
+
+// pdfString escapes a line for a PDF string literal in the WinAnsi encoding of the standard fonts.
+func pdfString(line string) string {
+	encoded, err := charmap.Windows1252.NewEncoder().String(line)
+	if err != nil {
+		encoded = asciiOnly(line)
+	}
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(encoded)
+}
+
+func asciiOnly(line string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 {
+			return '?'
+		}
+		return r
+	}, line)
+}
+
+func (doc *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	// Objects 1 to 4 are the catalog, the page tree and the fonts, then every page and its content
+	kids := make([]string, len(doc.pages))
+	for i := range doc.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range doc.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			page.width, page.height, pdfFont, pdfFontBold, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
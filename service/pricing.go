@@ -0,0 +1,129 @@
+package services
+
+import (
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrUnknownService      = errors.New("unknown service")
+	ErrInactiveService     = errors.New("service is no longer offered")
+	ErrInvalidPricingInput = errors.New("invalid pricing input")
+)
+
+type PricingService struct {
+	Repo    *repository.ServiceRepository
+	TaxRate float64
+}
+
+func NewPricingService(repo *repository.ServiceRepository, taxRate float64) *PricingService {
+	return &PricingService{Repo: repo, TaxRate: taxRate}
+}
+
+// PriceRequest links the request to the catalogue services ordered and recalculates the line items,
+// totals and load weight, ignoring any amounts the client sent. Prices are snapshotted into the line
+// items: services already on one of the previous line items keep the price they were ordered at, the
+// others take the current catalogue price and must still be active.
+func (ps *PricingService) PriceRequest(request *model.Request, orders []model.ServiceOrder, previous []model.RequestLineItem) error {
+	if request.DiscountPercent < 0 || request.DiscountPercent > 100 {
+		return fmt.Errorf("%w: discount_percent must be between 0 and 100", ErrInvalidPricingInput)
+	}
+	if request.LoadWeight < 0 {
+		return fmt.Errorf("%w: load_weight cannot be negative", ErrInvalidPricingInput)
+	}
+
+	ids := make([]int, 0, len(orders))
+	lineWeight := 0.0
+	for _, order := range orders {
+		if order.Quantity < 0 || order.Weight < 0 {
+			return fmt.Errorf("%w: quantity and weight of service %d cannot be negative", ErrInvalidPricingInput, order.ServiceID)
+		}
+		ids = append(ids, order.ServiceID)
+		lineWeight += order.Weight
+	}
+	if lineWeight > 0 {
+		request.LoadWeight = roundCents(lineWeight)
+	}
+	catalogue, err := ps.Repo.GetServicesByIDs(ids)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]*model.Service, len(catalogue))
+	for i := range catalogue {
+		byID[catalogue[i].Id] = &catalogue[i]
+	}
+	snapshots := make(map[int]model.RequestLineItem, len(previous))
+	for _, item := range previous {
+		snapshots[item.ServiceID] = item
+	}
+
+	services := make([]*model.Service, 0, len(orders))
+	lineItems := make([]model.RequestLineItem, 0, len(orders))
+	subtotal := 0.0
+	for _, order := range orders {
+		service, ok := byID[order.ServiceID]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownService, order.ServiceID)
+		}
+		description, unitPrice := service.Name, service.Price
+		if snapshot, ok := snapshots[service.Id]; ok {
+			description, unitPrice = snapshot.Description, snapshot.UnitPrice
+		} else if service.Inactive {
+			return fmt.Errorf("%w: %d", ErrInactiveService, service.Id)
+		}
+		quantity := order.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		charged := quantity
+		if service.PricedPerKg {
+			charged = order.Weight
+			if charged == 0 && lineWeight > 0 {
+				return fmt.Errorf("%w: service %d is priced per kg, its line needs a weight", ErrInvalidPricingInput, service.Id)
+			}
+			if charged == 0 {
+				charged = request.LoadWeight
+			}
+		}
+		total := roundCents(unitPrice * charged)
+		if !containsService(services, service.Id) {
+			services = append(services, service)
+		}
+		lineItems = append(lineItems, model.RequestLineItem{
+			RequestID:    request.Id,
+			ServiceID:    service.Id,
+			Description:  description,
+			GarmentType:  order.GarmentType,
+			Quantity:     quantity,
+			Weight:       order.Weight,
+			Instructions: order.Instructions,
+			UnitPrice:    unitPrice,
+			Total:        total,
+		})
+		subtotal += total
+	}
+
+	request.Services = services
+	request.LineItems = lineItems
+	request.Subtotal = roundCents(subtotal)
+	request.DiscountTotal = roundCents(request.Subtotal * request.DiscountPercent / 100)
+	request.TaxTotal = roundCents((request.Subtotal - request.DiscountTotal) * ps.TaxRate)
+	request.GrandTotal = roundCents(request.Subtotal - request.DiscountTotal + request.TaxTotal)
+	return nil
+}
+
+func containsService(services []*model.Service, id int) bool {
+	for _, service := range services {
+		if service.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"net"
+	"time"
+)
+
+// telemetryMaxSkew bounds how old or how far in the future a report may be. Within it, sequence numbers
+// keep captured reports from being replayed.
+const telemetryMaxSkew = 5 * time.Minute
+
+// TelemetryMaxFrameSize bounds a single report, over HTTP and over TCP.
+const TelemetryMaxFrameSize = 64 << 10
+
+// telemetryIdleTimeout closes TCP connections that stay silent for that long.
+const telemetryIdleTimeout = 2 * time.Minute
+
+var (
+	ErrInvalidTelemetrySignature = errors.New("invalid telemetry signature")
+	ErrStaleTelemetry            = errors.New("telemetry event is too old or too far in the future")
+	ErrReplayedTelemetry         = errors.New("telemetry event sequence number was already used")
+	ErrUnknownTelemetryEvent     = errors.New("unknown telemetry event type")
+	ErrInvalidTelemetryEvent     = errors.New("invalid telemetry event")
+)
+
+type TelemetryService struct {
+	Repo     *repository.WashingMachineRepository
+	Assigner *AssignmentService
+	Key      []byte
+}
+
+func NewTelemetryService(repo *repository.WashingMachineRepository, assigner *AssignmentService, key string) *TelemetryService {
+	return &TelemetryService{Repo: repo, Assigner: assigner, Key: []byte(key)}
+}
+
+// TelemetryFrame is one line sent over the TCP listener.
+type TelemetryFrame struct {
+	MachineID uuid.UUID       `json:"machine_id"`
+	Event     json.RawMessage `json:"event"`
+	Signature string          `json:"signature"`
+}
+
+// Ingest verifies the signature of a raw event sent by the machine and applies it.
+func (ts *TelemetryService) Ingest(machineId uuid.UUID, payload []byte, signature string) (model.TelemetryEvent, error) {
+	var event model.TelemetryEvent
+	if len(ts.Key) == 0 || !auth.VerifyTelemetry(auth.DeviceKey(ts.Key, machineId), payload, signature) {
+		return event, ErrInvalidTelemetrySignature
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, err
+	}
+	if skew := time.Since(event.SentAt); skew > telemetryMaxSkew || skew < -telemetryMaxSkew {
+		return event, ErrStaleTelemetry
+	}
+	if err := validateTelemetry(event); err != nil {
+		return event, err
+	}
+	if _, err := ts.Repo.GetWashingMachineByID(machineId.String()); err != nil {
+		return event, err
+	}
+	accepted, err := ts.Repo.ApplyTelemetry(machineId, event)
+	if err != nil {
+		return event, err
+	}
+	if !accepted {
+		return event, ErrReplayedTelemetry
+	}
+	switch event.Type {
+	case model.TelemetryCycleFinished:
+		log.Printf("Machine %s reported its cycle finished", machineId)
+		ts.Assigner.Notify()
+	case model.TelemetryError:
+		log.Printf("Machine %s reported error code %s", machineId, event.ErrorCode)
+		ts.Assigner.Notify()
+	}
+	return event, nil
+}
+
+// validateTelemetry checks the whole report before its sequence number is used up, so a controller can
+// fix and resend a report that was refused.
+func validateTelemetry(event model.TelemetryEvent) error {
+	if !event.Type.IsValid() {
+		return fmt.Errorf("%w: %s", ErrUnknownTelemetryEvent, event.Type)
+	}
+	if event.RemainingSeconds < 0 {
+		return fmt.Errorf("%w: negative remaining seconds", ErrInvalidTelemetryEvent)
+	}
+	if event.Type == model.TelemetryError && event.ErrorCode == "" {
+		return fmt.Errorf("%w: missing error code", ErrInvalidTelemetryEvent)
+	}
+	return nil
+}
+
+// ListenTCP accepts newline delimited TelemetryFrame JSON on addr until ctx is cancelled. Every frame
+// is answered with "ok" or "error: <reason>" on its own line.
+func (ts *TelemetryService) ListenTCP(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	log.Printf("Listening for machine telemetry on %s", addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Error accepting telemetry connection: %v", err)
+			continue
+		}
+		go ts.serveConn(ctx, conn)
+	}
+}
+
+func (ts *TelemetryService) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), TelemetryMaxFrameSize)
+	for conn.SetReadDeadline(time.Now().Add(telemetryIdleTimeout)) == nil && scanner.Scan() {
+		var frame TelemetryFrame
+		err := json.Unmarshal(scanner.Bytes(), &frame)
+		if err == nil {
+			_, err = ts.Ingest(frame.MachineID, frame.Event, frame.Signature)
+		}
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+			continue
+		}
+		fmt.Fprintln(conn, "ok")
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"LavanderiaBackend/model"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidScanCode   = errors.New("not a ticket or tag code")
+	ErrUnsupportedTicket = errors.New("unsupported ticket")
+)
+
+const (
+	TicketPDF    = "pdf"
+	TicketESCPOS = "escpos"
+
+	CodeCode128 = "code128"
+	CodeQR      = "qr" // Only on ESC/POS tickets, the printer draws it
+)
+
+const (
+	ticketCodeDigits = 40 // A request ID is a 128 bit number, at most 39 digits long
+	ticketColumns    = 42 // Fits an 80 mm roll on both the thermal printers and the PDF
+	ticketFontSize   = 8.0
+	ticketMargin     = 4 * pointsPerMM
+	ticketBarHeight  = 12 * pointsPerMM
+)
+
+// TicketCode is the code printed on the ticket of a request: its ID as a decimal number, which Code 128
+// packs two digits per symbol so the barcode stays narrow enough for an 80 mm roll.
+func TicketCode(id uuid.UUID) string {
+	digits := new(big.Int).SetBytes(id[:]).String()
+	return strings.Repeat("0", ticketCodeDigits-len(digits)) + digits
+}
+
+// TagCode is the code printed on the garment tag of a line item, the ticket code followed by the line ID.
+func TagCode(item *model.RequestLineItem) string {
+	return TicketCode(item.RequestID) + strconv.FormatUint(uint64(item.ID), 10)
+}
+
+// ParseScanCode returns the request a scanned ticket or tag code points to, and the line item for tags.
+// Request IDs typed in as they are shown under the codes are accepted too.
+func ParseScanCode(code string) (uuid.UUID, uint, error) {
+	code = strings.TrimSpace(code)
+	if id, err := uuid.Parse(code); err == nil {
+		return id, 0, nil
+	}
+	if len(code) < ticketCodeDigits || !isDigits(code) {
+		return uuid.Nil, 0, ErrInvalidScanCode
+	}
+	number, _ := new(big.Int).SetString(code[:ticketCodeDigits], 10)
+	if number.BitLen() > 128 {
+		return uuid.Nil, 0, ErrInvalidScanCode
+	}
+	var id uuid.UUID
+	number.FillBytes(id[:])
+	if len(code) == ticketCodeDigits {
+		return id, 0, nil
+	}
+	line, err := strconv.ParseUint(code[ticketCodeDigits:], 10, 32)
+	if err != nil || line == 0 {
+		return uuid.Nil, 0, ErrInvalidScanCode
+	}
+	return id, uint(line), nil
+}
+
+// ticketRow is a line of text on a ticket or tag.
+type ticketRow struct {
+	text   string
+	bold   bool
+	center bool
+}
+
+// ticketPart is the ticket itself or one of the garment tags, cut apart on the printer and on separate
+// pages in the PDF.
+type ticketPart struct {
+	rows []ticketRow
+	code string
+}
+
+// RenderTicket renders the ticket handed to the customer for a request, followed by a tag for every line
+// item when tags is set. The request needs its client and line items loaded.
+func RenderTicket(request *model.Request, format, code string, tags bool) ([]byte, error) {
+	parts := []ticketPart{ticketSlip(request)}
+	if tags {
+		for _, item := range sortedLineItems(request) {
+			parts = append(parts, garmentTag(request, item))
+		}
+	}
+
+	switch {
+	case format == TicketPDF && code == CodeCode128:
+		return renderTicketPDF(parts)
+	case format == TicketESCPOS && (code == CodeCode128 || code == CodeQR):
+		return renderTicketESCPOS(parts, code)
+	case format != TicketPDF && format != TicketESCPOS:
+		return nil, fmt.Errorf("%w: format must be %s or %s", ErrUnsupportedTicket, TicketPDF, TicketESCPOS)
+	case code == CodeQR:
+		return nil, fmt.Errorf("%w: QR codes are only printed on %s tickets", ErrUnsupportedTicket, TicketESCPOS)
+	}
+	return nil, fmt.Errorf("%w: code must be %s or %s", ErrUnsupportedTicket, CodeCode128, CodeQR)
+}
+
+func ticketSlip(request *model.Request) ticketPart {
+	rows := []ticketRow{
+		{text: "ORDER " + shortID(request.Id), bold: true, center: true},
+		{text: "Received " + request.OrderedDate.Format("2006-01-02 15:04"), center: true},
+		{},
+		{text: "Client: " + request.Client.Name},
+		{text: strings.Repeat("-", ticketColumns)},
+	}
+	for _, item := range sortedLineItems(request) {
+		name := item.Description
+		if item.GarmentType != "" {
+			name = item.GarmentType
+		}
+		rows = append(rows, ticketRow{text: spread(fmt.Sprintf("%g x %s", item.Quantity, name), money(item.Total))})
+		detail := "  " + item.Description
+		if item.Weight > 0 {
+			detail += fmt.Sprintf(", %g kg", item.Weight)
+		}
+		if item.GarmentType != "" || item.Weight > 0 {
+			rows = append(rows, ticketRow{text: detail})
+		}
+		for _, line := range wrap(item.Instructions, ticketColumns-4) {
+			rows = append(rows, ticketRow{text: "  * " + line})
+		}
+	}
+	rows = append(rows,
+		ticketRow{text: strings.Repeat("-", ticketColumns)},
+		ticketRow{text: spread("Subtotal", money(request.Subtotal))},
+	)
+	if request.DiscountTotal > 0 {
+		rows = append(rows, ticketRow{text: spread(fmt.Sprintf("Discount %g%%", request.DiscountPercent), "-"+money(request.DiscountTotal))})
+	}
+	rows = append(rows,
+		ticketRow{text: spread("Tax", money(request.TaxTotal))},
+		ticketRow{text: spread("TOTAL", money(request.GrandTotal)), bold: true},
+		ticketRow{},
+		ticketRow{text: "Show this ticket to pick up your order", center: true},
+	)
+	return ticketPart{rows: rows, code: TicketCode(request.Id)}
+}
+
+func garmentTag(request *model.Request, item model.RequestLineItem) ticketPart {
+	name := item.Description
+	if item.GarmentType != "" {
+		name = fmt.Sprintf("%s - %s", item.GarmentType, item.Description)
+	}
+	rows := []ticketRow{
+		{text: "ORDER " + shortID(request.Id), bold: true, center: true},
+		{text: request.Client.Name, center: true},
+		{text: fmt.Sprintf("%g x %s", item.Quantity, name)},
+	}
+	if item.Weight > 0 {
+		rows = append(rows, ticketRow{text: fmt.Sprintf("%g kg", item.Weight)})
+	}
+	for _, line := range wrap(item.Instructions, ticketColumns-2) {
+		rows = append(rows, ticketRow{text: "* " + line})
+	}
+	return ticketPart{rows: rows, code: TagCode(&item)}
+}
+
+func renderTicketPDF(parts []ticketPart) ([]byte, error) {
+	var doc pdfDocument
+	lineHeight := ticketFontSize * pdfLineSpace
+	charWidth := ticketFontSize * 0.6 // Courier glyphs are all 600 units wide
+	width := 2*ticketMargin + ticketColumns*charWidth
+	for _, part := range parts {
+		bars, err := Code128(part.code)
+		if err != nil {
+			return nil, err
+		}
+		height := 2*ticketMargin + float64(len(part.rows)+2)*lineHeight + ticketBarHeight
+		page := doc.addPage(width, height)
+		y := height - ticketMargin - ticketFontSize
+		for _, row := range part.rows {
+			x := ticketMargin
+			if row.center {
+				x = (width - float64(len([]rune(row.text)))*charWidth) / 2
+			}
+			page.text(x, y, ticketFontSize, row.bold, row.text)
+			y -= lineHeight
+		}
+		y -= ticketBarHeight
+		page.barcode(ticketMargin, y, width-2*ticketMargin, ticketBarHeight, bars)
+		y -= lineHeight
+		page.text((width-float64(len(part.code))*charWidth)/2, y, ticketFontSize, false, part.code)
+	}
+	return doc.bytes(), nil
+}
+
+func renderTicketESCPOS(parts []ticketPart, code string) ([]byte, error) {
+	w := newESCPOSWriter()
+	for _, part := range parts {
+		for _, row := range part.rows {
+			w.center(row.center)
+			w.bold(row.bold)
+			w.line(row.text)
+		}
+		w.bold(false)
+		w.center(true)
+		if code == CodeQR {
+			w.qr(part.code)
+			w.line(part.code)
+		} else if err := w.code128(part.code); err != nil {
+			return nil, err
+		}
+		w.center(false)
+		w.cut()
+	}
+	return w.bytes(), nil
+}
+
+func sortedLineItems(request *model.Request) []model.RequestLineItem {
+	items := append([]model.RequestLineItem(nil), request.LineItems...)
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// shortID is the start of a request ID, enough for staff to tell orders apart at the counter.
+func shortID(id uuid.UUID) string {
+	return strings.ToUpper(id.String()[:8])
+}
+
+func money(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// spread writes left and right at both ends of a ticket line.
+func spread(left, right string) string {
+	space := ticketColumns - len([]rune(left)) - len([]rune(right))
+	if space < 1 {
+		left = string([]rune(left)[:max(0, ticketColumns-len([]rune(right))-1)])
+		space = 1
+	}
+	return left + strings.Repeat(" ", space) + right
+}
+
+// wrap breaks text into lines of at most width characters, between words where it can.
+func wrap(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for len([]rune(word)) > width {
+			if line != "" {
+				lines, line = append(lines, line), ""
+			}
+			lines = append(lines, string([]rune(word)[:width]))
+			word = string([]rune(word)[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= width:
+			line += " " + word
+		default:
+			lines, line = append(lines, line), word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTicketCodeRoundTrip(t *testing.T) {
+	ids := []uuid.UUID{
+		uuid.Nil,
+		uuid.MustParse("00000000-0000-0000-0000-000000000001"),
+		uuid.MustParse("881bc1e9-95f6-498d-ae7c-7e65963a6012"),
+		uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff"),
+	}
+	for _, id := range ids {
+		code := TicketCode(id)
+		if len(code) != ticketCodeDigits || !isDigits(code) {
+			t.Errorf("TicketCode(%s) = %q, want %d digits", id, code, ticketCodeDigits)
+		}
+		for line, scanned := range map[uint]string{0: code, 7: code + "7", 123456: code + "123456"} {
+			gotID, gotLine, err := ParseScanCode(scanned)
+			if err != nil || gotID != id || gotLine != line {
+				t.Errorf("ParseScanCode(%q) = %s, %d, %v, want %s, %d", scanned, gotID, gotLine, err, id, line)
+			}
+		}
+	}
+}
+
+func TestParseScanCode(t *testing.T) {
+	id := uuid.MustParse("881bc1e9-95f6-498d-ae7c-7e65963a6012")
+	tests := []struct {
+		code string
+		id   uuid.UUID
+		line uint
+		err  error
+	}{
+		{code: id.String(), id: id},
+		{code: "  " + id.String() + "\n", id: id},
+		{code: TicketCode(id) + "\r\n", id: id},
+		{code: "", err: ErrInvalidScanCode},
+		{code: "not a code", err: ErrInvalidScanCode},
+		{code: TicketCode(id)[1:], err: ErrInvalidScanCode},                    // Too short
+		{code: TicketCode(id) + "x", err: ErrInvalidScanCode},                  // Not digits
+		{code: TicketCode(id) + "0", err: ErrInvalidScanCode},                  // Line IDs start at 1
+		{code: TicketCode(id) + "99999999999", err: ErrInvalidScanCode},        // Line ID overflows
+		{code: strings.Repeat("9", ticketCodeDigits), err: ErrInvalidScanCode}, // Above 128 bits
+	}
+	for _, test := range tests {
+		gotID, gotLine, err := ParseScanCode(test.code)
+		if !errors.Is(err, test.err) || gotID != test.id || gotLine != test.line {
+			t.Errorf("ParseScanCode(%q) = %s, %d, %v, want %s, %d, %v", test.code, gotID, gotLine, err, test.id, test.line, test.err)
+		}
+	}
+}
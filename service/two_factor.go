@@ -0,0 +1,123 @@
+package services
+
+import (
+	"LavanderiaBackend/api/auth"
+	"LavanderiaBackend/model"
+	"LavanderiaBackend/repository"
+	"errors"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("start a two-factor enrollment first")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is mandatory for this role")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+type TwoFactorService struct {
+	Users *repository.UserRepository
+	Roles *repository.RoleRepository
+}
+
+func NewTwoFactorService(users *repository.UserRepository, roles *repository.RoleRepository) *TwoFactorService {
+	return &TwoFactorService{Users: users, Roles: roles}
+}
+
+// Required reports whether the role of the user makes two-factor authentication mandatory.
+func (ts *TwoFactorService) Required(user model.User) (bool, error) {
+	role, err := ts.Roles.GetRoleByName(user.Role)
+	if err != nil {
+		return false, err
+	}
+	return role.RequireTwoFactor, nil
+}
+
+// Enroll generates a new secret for the user and returns it with its provisioning URI. Nothing
+// changes for the user until Confirm is called with a code from it.
+func (ts *TwoFactorService) Enroll(user model.User) (string, string, error) {
+	if user.HasTwoFactor() {
+		return "", "", ErrTwoFactorEnabled
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := ts.Users.SetTOTPSecret(user.Id, secret); err != nil {
+		return "", "", err
+	}
+	return secret, auth.TOTPProvisioningURI(secret, user.Username), nil
+}
+
+// Confirm enables two-factor authentication when code matches the enrolled secret, and returns the
+// recovery codes. They are only ever shown here.
+func (ts *TwoFactorService) Confirm(user model.User, code string) ([]string, error) {
+	if user.HasTwoFactor() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	step, ok := auth.VerifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	return codes, ts.Users.EnableTOTP(user.Id, step, hashes)
+}
+
+// Verify checks a code from the authenticator, or when code is empty, spends a recovery code.
+func (ts *TwoFactorService) Verify(user model.User, code string, recoveryCode string) error {
+	if !user.HasTwoFactor() {
+		return ErrTwoFactorNotEnrolled
+	}
+	var ok bool
+	var err error
+	if code != "" {
+		var step int64
+		if step, ok = auth.VerifyTOTP(user.TOTPSecret, code, time.Now()); ok {
+			ok, err = ts.Users.AcceptTOTPStep(user.Id, step)
+		}
+	} else if recoveryCode != "" {
+		ok, err = ts.Users.UseRecoveryCode(user.Id, auth.HashRecoveryCode(recoveryCode))
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// Disable turns two-factor authentication off, unless the role of the user requires it.
+func (ts *TwoFactorService) Disable(user model.User, code string) error {
+	required, err := ts.Required(user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorRequired
+	}
+	if err := ts.Verify(user, code, ""); err != nil {
+		return err
+	}
+	return ts.Users.DisableTOTP(user.Id)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user with new ones.
+func (ts *TwoFactorService) RegenerateRecoveryCodes(user model.User, code string) ([]string, error) {
+	if err := ts.Verify(user, code, ""); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	return codes, ts.Users.ReplaceRecoveryCodes(user.Id, hashes)
+}